@@ -10,13 +10,12 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"net"
 	"os"
 	osignal "os/signal"
 	"path/filepath"
-	"strconv"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/kr/pty"
 
@@ -25,10 +24,10 @@ import (
 	"github.com/spf13/cobra"
 	"github.com/sylabs/singularity/internal/pkg/buildcfg"
 	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/instance/monitor"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
 	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci"
 	"github.com/sylabs/singularity/internal/pkg/sylog"
-	"github.com/sylabs/singularity/internal/pkg/util/exec"
 	"github.com/sylabs/singularity/internal/pkg/util/signal"
 	"github.com/sylabs/singularity/internal/pkg/util/unix"
 	"github.com/sylabs/singularity/pkg/ociruntime"
@@ -37,8 +36,13 @@ import (
 
 var bundlePath string
 var logPath string
+var logSizeMax int64
 var syncSocketPath string
 var emptyProcess bool
+var ociRuntimeName string
+var healthcheckCmd string
+var healthcheckInterval time.Duration
+var streamAddr string
 
 func init() {
 	SingularityCmd.AddCommand(OciCmd)
@@ -49,13 +53,26 @@ func init() {
 	OciCreateCmd.Flags().StringVarP(&syncSocketPath, "sync-socket", "s", "", "specify the path to unix socket for state synchronization (internal)")
 	OciCreateCmd.Flags().SetAnnotation("sync-socket", "argtag", []string{"<path>"})
 	OciCreateCmd.Flags().BoolVar(&emptyProcess, "empty-process", false, "run container without executing container process (eg: for POD container)")
-	OciCreateCmd.Flags().StringVarP(&logPath, "log-path", "l", "", "specify the log file path")
+	OciCreateCmd.Flags().StringVarP(&logPath, "log-path", "l", "", "specify the base path for the container's rotated CRI-format log")
 	OciCreateCmd.Flags().SetAnnotation("log-path", "argtag", []string{"<path>"})
+	OciCreateCmd.Flags().Int64Var(&logSizeMax, "log-size-max", monitor.DefaultLogSizeMax, "rotate the container log once it exceeds this many bytes")
+	OciCreateCmd.Flags().StringVar(&ociRuntimeName, "oci-runtime", "", "OCI runtime backend to use, as configured in the [oci runtimes] table of singularity.conf (default: the built-in starter)")
+	OciCreateCmd.Flags().SetAnnotation("oci-runtime", "argtag", []string{"<name>"})
+	OciCreateCmd.Flags().StringVar(&healthcheckCmd, "healthcheck-cmd", "", "shell command to run periodically to check the container's health; injected as a healthcheck annotation when the bundle doesn't already define one")
+	OciCreateCmd.Flags().SetAnnotation("healthcheck-cmd", "argtag", []string{"<command>"})
+	OciCreateCmd.Flags().DurationVar(&healthcheckInterval, "healthcheck-interval", oci.DefaultHealthcheckInterval, "how often to run the injected healthcheck command")
 
 	OciStartCmd.Flags().SetInterspersed(false)
 	OciDeleteCmd.Flags().SetInterspersed(false)
 	OciAttachCmd.Flags().SetInterspersed(false)
+	OciAttachCmd.Flags().StringVar(&streamAddr, "stream-addr", "", "serve this attach session over a kubelet-style streaming endpoint instead of local stdio, eg unix:///run/singularity/streams/<id>.sock")
+	OciAttachCmd.Flags().SetAnnotation("stream-addr", "argtag", []string{"<addr>"})
+
 	OciExecCmd.Flags().SetInterspersed(false)
+	OciExecCmd.Flags().StringVar(&ociRuntimeName, "oci-runtime", "", "OCI runtime backend to use (default: the backend the container was created with)")
+	OciExecCmd.Flags().SetAnnotation("oci-runtime", "argtag", []string{"<name>"})
+	OciExecCmd.Flags().StringVar(&streamAddr, "stream-addr", "", "serve this exec session over a kubelet-style streaming endpoint instead of local stdio, eg unix:///run/singularity/streams/<id>.sock")
+	OciExecCmd.Flags().SetAnnotation("stream-addr", "argtag", []string{"<addr>"})
 
 	OciStateCmd.Flags().SetInterspersed(false)
 	OciStateCmd.Flags().StringVarP(&syncSocketPath, "sync-socket", "s", "", "specify the path to unix socket for state synchronization (internal)")
@@ -67,8 +84,14 @@ func init() {
 	OciRunCmd.Flags().SetInterspersed(false)
 	OciRunCmd.Flags().StringVarP(&bundlePath, "bundle", "b", "", "specify the OCI bundle path")
 	OciRunCmd.Flags().SetAnnotation("bundle", "argtag", []string{"<path>"})
-	OciRunCmd.Flags().StringVarP(&logPath, "log-path", "l", "", "specify the log file path")
+	OciRunCmd.Flags().StringVarP(&logPath, "log-path", "l", "", "specify the base path for the container's rotated CRI-format log")
 	OciRunCmd.Flags().SetAnnotation("log-path", "argtag", []string{"<path>"})
+	OciRunCmd.Flags().Int64Var(&logSizeMax, "log-size-max", monitor.DefaultLogSizeMax, "rotate the container log once it exceeds this many bytes")
+	OciRunCmd.Flags().StringVar(&ociRuntimeName, "oci-runtime", "", "OCI runtime backend to use, as configured in the [oci runtimes] table of singularity.conf (default: the built-in starter)")
+	OciRunCmd.Flags().SetAnnotation("oci-runtime", "argtag", []string{"<name>"})
+	OciRunCmd.Flags().StringVar(&healthcheckCmd, "healthcheck-cmd", "", "shell command to run periodically to check the container's health; injected as a healthcheck annotation when the bundle doesn't already define one")
+	OciRunCmd.Flags().SetAnnotation("healthcheck-cmd", "argtag", []string{"<command>"})
+	OciRunCmd.Flags().DurationVar(&healthcheckInterval, "healthcheck-interval", oci.DefaultHealthcheckInterval, "how often to run the injected healthcheck command")
 
 	OciCmd.AddCommand(OciStartCmd)
 	OciCmd.AddCommand(OciCreateCmd)
@@ -257,40 +280,39 @@ func getState(containerID string) (*specs.State, error) {
 }
 
 func resize(controlSocket string, oversized bool) {
-	ctrl := &ociruntime.Control{}
-	ctrl.ConsoleSize = &specs.Box{}
-
-	c, err := unix.Dial(controlSocket)
-	if err != nil {
-		sylog.Errorf("failed to connect to control socket")
-		return
-	}
-	defer c.Close()
-
 	rows, cols, err := pty.Getsize(os.Stdin)
 	if err != nil {
 		sylog.Errorf("terminal resize error: %s", err)
 		return
 	}
 
-	ctrl.ConsoleSize.Height = uint(rows)
-	ctrl.ConsoleSize.Width = uint(cols)
-
 	if oversized {
-		ctrl.ConsoleSize.Height++
-		ctrl.ConsoleSize.Width++
+		rows++
+		cols++
 	}
 
-	enc := json.NewEncoder(c)
-	if err != nil {
+	if err := sendControlResize(controlSocket, uint(rows), uint(cols)); err != nil {
 		sylog.Errorf("%s", err)
-		return
 	}
+}
 
-	if err := enc.Encode(ctrl); err != nil {
-		sylog.Errorf("%s", err)
-		return
+// sendControlResize encodes a console resize to rows/cols and sends it over
+// controlSocket, the same message "oci attach"'s local SIGWINCH handler and
+// a remote "--stream-addr" resize frame both boil down to.
+func sendControlResize(controlSocket string, rows, cols uint) error {
+	ctrl := &ociruntime.Control{}
+	ctrl.ConsoleSize = &specs.Box{
+		Height: rows,
+		Width:  cols,
+	}
+
+	c, err := unix.Dial(controlSocket)
+	if err != nil {
+		return fmt.Errorf("failed to connect to control socket: %s", err)
 	}
+	defer c.Close()
+
+	return json.NewEncoder(c).Encode(ctrl)
 }
 
 func attach(attachSocket, controlSocket string, engineConfig *oci.EngineConfig) error {
@@ -356,99 +378,62 @@ func attach(attachSocket, controlSocket string, engineConfig *oci.EngineConfig)
 	return nil
 }
 
-func exitContainer(containerID string, syncSocketPath string) {
-	state, err := getState(containerID)
+// exitContainer reads the exit status the monitor recorded for containerID
+// and terminates the CLI process with it. The monitor keeps running and
+// serving the attach socket independently of the CLI, so this no longer
+// needs a sync socket to learn the exit status: it just reads the exit file
+// the monitor already wrote. When cleanup is set (the CLI drove the full
+// create+start+attach lifecycle via ociRun) the instance is also deleted.
+func exitContainer(containerID string, cleanup bool) {
+	dir, err := instance.GetDirPrivileged(containerID)
 	if err != nil {
 		sylog.Errorf("%s", err)
 		os.Exit(1)
 	}
 
-	if _, ok := state.Annotations[ociruntime.AnnotationExitCode]; ok {
-		code := state.Annotations[ociruntime.AnnotationExitCode]
-		exitCode, err := strconv.Atoi(code)
-		if err != nil {
-			sylog.Errorf("%s", err)
-			defer os.Exit(1)
-		} else {
-			defer os.Exit(exitCode)
-		}
+	exitCode, err := monitor.ReadExitStatus(dir)
+	if err != nil {
+		sylog.Errorf("failed to read container exit status: %s", err)
+		os.Exit(1)
 	}
 
-	if syncSocketPath != "" {
+	if cleanup {
+		defer os.Exit(exitCode)
 		if err := ociDelete(containerID); err != nil {
 			sylog.Errorf("%s", err)
 		}
+		return
 	}
+
+	os.Exit(exitCode)
 }
 
+// ociRun creates the container, starts it, and attaches to the monitor's
+// persistent attach/control sockets. The monitor is already serving those
+// sockets by the time ociCreate returns, so there's no state-machine to
+// drive here any more: just create, start, attach.
 func ociRun(containerID string) error {
-	dir, err := instance.GetDirPrivileged(containerID)
-	if err != nil {
-		return err
-	}
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return err
-	}
-	syncSocketPath = filepath.Join(dir, "run.sock")
-
-	l, err := net.Listen("unix", syncSocketPath)
-	if err != nil {
-		os.Remove(syncSocketPath)
+	if err := ociCreate(containerID); err != nil {
 		return err
 	}
 
-	defer l.Close()
-	defer exitContainer(containerID, syncSocketPath)
-	defer os.Remove(syncSocketPath)
-
-	if err := ociCreate(containerID); err != nil {
+	if err := ociStart(containerID); err != nil {
 		return err
 	}
 
-	start := make(chan string, 1)
-
-	go func() {
-		var state specs.State
-
-		for {
-			c, err := l.Accept()
-			if err != nil {
-				return
-			}
-
-			dec := json.NewDecoder(c)
-			if err := dec.Decode(&state); err != nil {
-				return
-			}
-
-			c.Close()
-
-			switch state.Status {
-			case "created":
-				if err := ociStart(containerID); err != nil {
-					return
-				}
-			case "running":
-				start <- state.Annotations[ociruntime.AnnotationAttachSocket]
-			case "stopped":
-				return
-			}
-		}
-	}()
-
-	attachSocket := <-start
+	defer exitContainer(containerID, true)
 
 	engineConfig, err := getEngineConfig(containerID)
 	if err != nil {
 		return err
 	}
 
-	controlSocket, ok := engineConfig.State.Annotations[ociruntime.AnnotationControlSocket]
-	if !ok {
-		return fmt.Errorf("control socket not available, container state: %s", engineConfig.State.Status)
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
 	}
 
-	return attach(attachSocket, controlSocket, engineConfig)
+	return attach(monitor.AttachSocketPath(dir), monitor.ControlSocketPath(dir), engineConfig)
 }
 
 func ociAttach(containerID string) error {
@@ -457,50 +442,75 @@ func ociAttach(containerID string) error {
 		return err
 	}
 
-	state := engineConfig.GetState()
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
 
-	attachSocket, ok := state.Annotations[ociruntime.AnnotationAttachSocket]
-	if !ok {
-		return fmt.Errorf("attach socket not available, container state: %s", state.Status)
+	if streamAddr != "" {
+		return attachOverStream(streamAddr, monitor.AttachSocketPath(dir), monitor.ControlSocketPath(dir))
 	}
-	controlSocket, ok := state.Annotations[ociruntime.AnnotationControlSocket]
-	if !ok {
-		return fmt.Errorf("control socket not available, container state: %s", state.Status)
+
+	defer exitContainer(containerID, false)
+
+	return attach(monitor.AttachSocketPath(dir), monitor.ControlSocketPath(dir), engineConfig)
+}
+
+// runtimesConfigPath is where the "[oci runtimes]" table mapping runtime
+// names to binaries is read from.
+const runtimesConfigPath = buildcfg.SYSCONFDIR + "/singularity/singularity.conf"
+
+// loadRuntimeBinaries reads the [oci runtimes] table from singularity.conf,
+// returning an empty map (not an error) when the file can't be read so the
+// built-in starter backend keeps working on installs that haven't
+// configured any external runtime.
+func loadRuntimeBinaries() map[string]oci.RuntimeBinary {
+	f, err := os.Open(runtimesConfigPath)
+	if err != nil {
+		return map[string]oci.RuntimeBinary{}
 	}
+	defer f.Close()
 
-	defer exitContainer(containerID, "")
+	binaries, err := oci.LoadRuntimesConfig(f)
+	if err != nil {
+		sylog.Warningf("failed to parse %s: %s", runtimesConfigPath, err)
+		return map[string]oci.RuntimeBinary{}
+	}
+	return binaries
+}
 
-	return attach(attachSocket, controlSocket, engineConfig)
+// runtimeFor constructs the Runtime backend named name, falling back to
+// whichever backend created containerID when name is empty.
+func runtimeFor(containerID, name string) (oci.Runtime, error) {
+	if name == "" {
+		engineConfig, err := getEngineConfig(containerID)
+		if err == nil {
+			name = engineConfig.RuntimeName
+		}
+	}
+	return oci.NewRuntime(name, loadRuntimeBinaries())
 }
 
 func ociStart(containerID string) error {
-	state, err := getState(containerID)
+	engineConfig, err := getEngineConfig(containerID)
 	if err != nil {
 		return err
 	}
 
-	if state.Status != "created" {
-		return fmt.Errorf("container %s is not created", containerID)
-	}
-
-	// send SIGCONT signal to the instance
-	if err := syscall.Kill(state.Pid, syscall.SIGCONT); err != nil {
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
+	if err != nil {
 		return err
 	}
-	return nil
+
+	return runtime.Start(containerID, engineConfig)
 }
 
 func ociKill(containerID string) error {
-	// send signal to the instance
-	state, err := getState(containerID)
+	engineConfig, err := getEngineConfig(containerID)
 	if err != nil {
 		return err
 	}
 
-	if state.Status != "created" && state.Status != "running" {
-		return fmt.Errorf("container %s is nor created nor running", containerID)
-	}
-
 	sig := syscall.SIGTERM
 
 	if stopSignal != "" {
@@ -510,7 +520,12 @@ func ociKill(containerID string) error {
 		}
 	}
 
-	return syscall.Kill(state.Pid, sig)
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
+	if err != nil {
+		return err
+	}
+
+	return runtime.Kill(containerID, engineConfig, int(sig))
 }
 
 func ociDelete(containerID string) error {
@@ -519,33 +534,56 @@ func ociDelete(containerID string) error {
 		return err
 	}
 
-	if engineConfig.State.Status != "stopped" {
-		return fmt.Errorf("container is not stopped")
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
+	if err != nil {
+		return err
 	}
 
-	hooks := engineConfig.OciConfig.Hooks
-	if hooks != nil {
-		for _, h := range hooks.Poststop {
-			if err := exec.Hook(&h, &engineConfig.State); err != nil {
-				sylog.Warningf("%s", err)
-			}
-		}
+	return runtime.Delete(containerID, engineConfig)
+}
+
+func ociState(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return err
 	}
 
-	// remove instance files
-	file, err := instance.Get(containerID)
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
 	if err != nil {
 		return err
 	}
-	return file.Delete()
-}
 
-func ociState(containerID string) error {
-	// query instance files and returns state
-	state, err := getState(containerID)
+	// Ask the backend that actually owns the container for its live state,
+	// rather than the create-time snapshot in the instance file: for an
+	// external runtime (runc/crun) that's the only way to see anything
+	// past "created", since nothing else in this CLI process keeps that
+	// file's state in sync with the container's real status.
+	state, err := runtime.State(containerID, engineConfig)
 	if err != nil {
 		return err
 	}
+
+	if dir, err := instance.GetDirPrivileged(containerID); err == nil {
+		if status, ok := monitor.ReadHealthStatus(dir); ok {
+			if state.Annotations == nil {
+				state.Annotations = make(map[string]string)
+			}
+			state.Annotations[oci.AnnotationHealthStatus] = status
+		}
+
+		if oci.IsPaused(dir) {
+			if state.Annotations == nil {
+				state.Annotations = make(map[string]string)
+			}
+			state.Annotations[oci.AnnotationPaused] = "true"
+			// "paused" is itself a valid OCI status: report it there too,
+			// not just as an annotation, so clients that only look at
+			// Status (rather than this Sylabs-specific annotation) still
+			// see the container is frozen.
+			state.Status = "paused"
+		}
+	}
+
 	if syncSocketPath != "" {
 		data, err := json.Marshal(state)
 		if err != nil {
@@ -564,8 +602,6 @@ func ociState(containerID string) error {
 }
 
 func ociCreate(containerID string) error {
-	starter := buildcfg.LIBEXECDIR + "/singularity/bin/starter"
-
 	_, err := getState(containerID)
 	if err == nil {
 		return fmt.Errorf("%s already exists", containerID)
@@ -605,52 +641,52 @@ func ociCreate(containerID string) error {
 		return fmt.Errorf("failed to parse %s: %s", configJSON, err)
 	}
 
-	Env := []string{sylog.GetEnvVar(), "SRUNTIME=oci"}
-
 	engineConfig.EmptyProcess = emptyProcess
 	engineConfig.SyncSocket = syncSocketPath
+	engineConfig.RuntimeName = ociRuntimeName
+	engineConfig.LogSizeMax = logSizeMax
 
-	commonConfig := &config.Common{
-		ContainerID:  containerID,
-		EngineName:   "oci",
-		EngineConfig: engineConfig,
+	if healthcheckCmd != "" {
+		if engineConfig.OciConfig.Spec.Annotations == nil {
+			engineConfig.OciConfig.Spec.Annotations = make(map[string]string)
+		}
+		if _, ok := engineConfig.OciConfig.Spec.Annotations[oci.AnnotationHealthcheckCmd]; !ok {
+			engineConfig.OciConfig.Spec.Annotations[oci.AnnotationHealthcheckCmd] = healthcheckCmd
+			engineConfig.OciConfig.Spec.Annotations[oci.AnnotationHealthcheckInterval] = healthcheckInterval.String()
+		}
 	}
 
-	configData, err := json.Marshal(commonConfig)
+	runtime, err := oci.NewRuntime(ociRuntimeName, loadRuntimeBinaries())
 	if err != nil {
-		sylog.Fatalf("%s", err)
+		return err
 	}
 
-	procName := fmt.Sprintf("Singularity OCI %s", containerID)
-	cmd, err := exec.PipeCommand(starter, []string{procName}, Env, configData)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	return cmd.Run()
+	return runtime.Create(containerID, engineConfig)
 }
 
 func ociExec(containerID string, cmdArgs []string) error {
-	starter := buildcfg.LIBEXECDIR + "/singularity/bin/starter"
-
-	commonConfig, err := getCommonConfig(containerID)
+	engineConfig, err := getEngineConfig(containerID)
 	if err != nil {
 		return fmt.Errorf("%s doesn't exist", containerID)
 	}
 
-	engineConfig := commonConfig.EngineConfig.(*oci.EngineConfig)
+	name := ociRuntimeName
+	if name == "" {
+		name = engineConfig.RuntimeName
+	}
 
-	engineConfig.Exec = true
-	engineConfig.OciConfig.SetProcessArgs(cmdArgs)
+	runtime, err := runtimeFor(containerID, name)
+	if err != nil {
+		return err
+	}
 
 	os.Clearenv()
 
-	configData, err := json.Marshal(commonConfig)
-	if err != nil {
-		sylog.Fatalf("%s", err)
+	if streamAddr != "" {
+		return execOverStream(streamAddr, func() error {
+			return runtime.Exec(containerID, engineConfig, cmdArgs)
+		})
 	}
 
-	Env := []string{sylog.GetEnvVar(), "SRUNTIME=oci"}
-
-	procName := fmt.Sprintf("Singularity OCI %s", containerID)
-	return exec.Pipe(starter, []string{procName}, Env, configData)
+	return runtime.Exec(containerID, engineConfig, cmdArgs)
 }
@@ -0,0 +1,81 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+var (
+	checkpointExport         string
+	checkpointLeaveRunning   bool
+	checkpointTCPEstablished bool
+	checkpointKeep           bool
+)
+
+func init() {
+	OciCheckpointCmd.Flags().SetInterspersed(false)
+	OciCheckpointCmd.Flags().StringVar(&checkpointExport, "export", "", "pack the checkpoint into a tar archive at this path")
+	OciCheckpointCmd.Flags().SetAnnotation("export", "argtag", []string{"<path>"})
+	OciCheckpointCmd.Flags().BoolVar(&checkpointLeaveRunning, "leave-running", false, "leave the container running after checkpointing it")
+	OciCheckpointCmd.Flags().BoolVar(&checkpointTCPEstablished, "tcp-established", false, "allow checkpointing established TCP connections")
+	OciCheckpointCmd.Flags().BoolVar(&checkpointKeep, "keep", false, "keep the checkpoint directory around after packing it into --export")
+
+	OciCmd.AddCommand(OciCheckpointCmd)
+}
+
+// OciCheckpointCmd represents oci checkpoint command
+var OciCheckpointCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ociCheckpoint(args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "checkpoint",
+	Short:   "oci checkpoint",
+	Long:    "oci checkpoint",
+	Example: "oci checkpoint",
+}
+
+// checkpointDirPath is where a container's own (unexported) checkpoint is
+// kept inside its instance directory, absent --export moving it elsewhere.
+func checkpointDirPath(instanceDir string) string {
+	return filepath.Join(instanceDir, "checkpoint")
+}
+
+func ociCheckpoint(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return fmt.Errorf("%s doesn't exist", containerID)
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
+	if err != nil {
+		return err
+	}
+
+	opts := oci.CheckpointOptions{
+		Export:         checkpointExport,
+		LeaveRunning:   checkpointLeaveRunning,
+		TCPEstablished: checkpointTCPEstablished,
+		Keep:           checkpointKeep,
+	}
+
+	return runtime.Checkpoint(containerID, engineConfig, checkpointDirPath(dir), opts)
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/instance/monitor"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	OciCmd.AddCommand(OciHealthcheckCmd)
+}
+
+// OciHealthcheckCmd represents oci healthcheck command
+var OciHealthcheckCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ociHealthcheck(args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "healthcheck",
+	Short:   "oci healthcheck",
+	Long:    "oci healthcheck",
+	Example: "oci healthcheck",
+}
+
+// ociHealthcheck runs the container's healthcheck command on demand,
+// recording the result in the same ring buffer and status file the
+// monitor's own periodic schedule uses, so "oci state" and later
+// "oci healthcheck" calls see a consistent history either way.
+func ociHealthcheck(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return fmt.Errorf("%s doesn't exist", containerID)
+	}
+
+	spec, ok := oci.HealthcheckSpecFromAnnotations(engineConfig.OciConfig.Spec.Annotations)
+	if !ok {
+		return fmt.Errorf("%s has no healthcheck configured", containerID)
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+
+	result := monitor.RunHealthcheck(containerID, spec.Cmd, spec.Timeout)
+
+	if err := monitor.AppendHealthcheckResult(dir, result); err != nil {
+		sylog.Warningf("failed to record healthcheck result: %s", err)
+	}
+
+	status := monitor.HealthStatusHealthy
+	if result.ExitCode != 0 {
+		status = monitor.HealthStatusUnhealthy
+	}
+	if err := monitor.WriteHealthStatus(dir, status); err != nil {
+		sylog.Warningf("failed to write health status: %s", err)
+	}
+
+	c, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(c))
+
+	if result.ExitCode != 0 {
+		return fmt.Errorf("healthcheck failed with exit code %d", result.ExitCode)
+	}
+	return nil
+}
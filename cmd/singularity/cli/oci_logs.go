@@ -0,0 +1,131 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/instance/monitor"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+var (
+	logsFollow     bool
+	logsTail       int
+	logsSince      string
+	logsTimestamps bool
+)
+
+func init() {
+	OciLogsCmd.Flags().SetInterspersed(false)
+	OciLogsCmd.Flags().BoolVarP(&logsFollow, "follow", "f", false, "keep printing new log lines as the container produces them")
+	OciLogsCmd.Flags().IntVar(&logsTail, "tail", 0, "only show the last N log lines (0 means all)")
+	OciLogsCmd.Flags().StringVar(&logsSince, "since", "", "only show log lines newer than this duration (eg: 10m, 1h30m)")
+	OciLogsCmd.Flags().BoolVar(&logsTimestamps, "timestamps", false, "prefix each log line with its timestamp")
+
+	OciCmd.AddCommand(OciLogsCmd)
+}
+
+// OciLogsCmd represents oci logs command
+var OciLogsCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ociLogs(args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "logs",
+	Short:   "oci logs",
+	Long:    "oci logs",
+	Example: "oci logs",
+}
+
+func ociLogs(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return err
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+
+	basePath := engineConfig.GetLogPath()
+	if basePath == "" {
+		basePath = monitor.LogPath(dir)
+	}
+
+	var sinceTime time.Time
+	if logsSince != "" {
+		d, err := time.ParseDuration(logsSince)
+		if err != nil {
+			return fmt.Errorf("invalid --since duration %q: %s", logsSince, err)
+		}
+		sinceTime = time.Now().Add(-d)
+	}
+
+	printed := 0
+
+	// printed always indexes into the full, un-tailed, since-filtered
+	// entries: --tail only trims what the first call prints, not what
+	// later follow reads consider already-printed, or every follow
+	// iteration would re-slice the full list by an index that was only
+	// ever valid against the shorter tailed one and reprint most of the
+	// container's history.
+	show := func(applyTail bool) error {
+		entries, err := monitor.ReadLog(basePath)
+		if err != nil {
+			return err
+		}
+
+		entries = monitor.Since(entries, sinceTime)
+
+		// Rotation can drop the oldest segment between reads, shrinking
+		// entries below printed; clamp instead of slicing out of range.
+		if printed > len(entries) {
+			printed = len(entries)
+		}
+
+		toPrint := entries[printed:]
+		if applyTail {
+			toPrint = monitor.Tail(entries, logsTail)
+		}
+
+		for _, e := range toPrint {
+			printLogEntry(e)
+		}
+		printed = len(entries)
+		return nil
+	}
+
+	if err := show(true); err != nil {
+		return err
+	}
+
+	if !logsFollow {
+		return nil
+	}
+
+	for {
+		time.Sleep(500 * time.Millisecond)
+		if err := show(false); err != nil {
+			return err
+		}
+	}
+}
+
+func printLogEntry(e monitor.LogEntry) {
+	if logsTimestamps {
+		fmt.Printf("%s %s\n", e.Time.Format(time.RFC3339Nano), e.Payload)
+		return
+	}
+	fmt.Println(e.Payload)
+}
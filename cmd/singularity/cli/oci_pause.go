@@ -0,0 +1,74 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	OciCmd.AddCommand(OciPauseCmd)
+	OciCmd.AddCommand(OciResumeCmd)
+}
+
+// OciPauseCmd represents oci pause command
+var OciPauseCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ociPause(args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "pause",
+	Short:   "oci pause",
+	Long:    "oci pause",
+	Example: "oci pause",
+}
+
+// OciResumeCmd represents oci resume command
+var OciResumeCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ociResume(args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "resume",
+	Short:   "oci resume",
+	Long:    "oci resume",
+	Example: "oci resume",
+}
+
+func ociPause(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
+	if err != nil {
+		return err
+	}
+
+	return runtime.Pause(containerID, engineConfig)
+}
+
+func ociResume(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return err
+	}
+
+	runtime, err := runtimeFor(containerID, engineConfig.RuntimeName)
+	if err != nil {
+		return err
+	}
+
+	return runtime.Resume(containerID, engineConfig)
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/opencontainers/runtime-tools/generate"
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+func init() {
+	OciRestoreCmd.Flags().SetInterspersed(false)
+	OciRestoreCmd.Flags().StringVar(&ociRuntimeName, "oci-runtime", "", "OCI runtime backend to use, as configured in the [oci runtimes] table of singularity.conf (default: the built-in starter)")
+	OciRestoreCmd.Flags().SetAnnotation("oci-runtime", "argtag", []string{"<name>"})
+
+	OciCmd.AddCommand(OciRestoreCmd)
+}
+
+// OciRestoreCmd represents oci restore command
+var OciRestoreCmd = &cobra.Command{
+	Args:                  cobra.RangeArgs(1, 2),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		archivePath := ""
+		if len(args) > 1 {
+			archivePath = args[1]
+		}
+		if err := ociRestore(args[0], archivePath); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "restore",
+	Short:   "oci restore",
+	Long:    "oci restore",
+	Example: "oci restore",
+}
+
+// ociRestore re-creates containerID's instance directory from a checkpoint
+// and launches it in restore mode. archivePath, when given, is a tar
+// archive produced by "oci checkpoint --export" that gets unpacked into the
+// container's own checkpoint directory; left empty, that directory is
+// expected to already hold an unexported checkpoint.
+func ociRestore(containerID, archivePath string) error {
+	_, err := getState(containerID)
+	if err == nil {
+		return fmt.Errorf("%s already exists", containerID)
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+
+	checkpointDir := checkpointDirPath(dir)
+
+	if archivePath != "" {
+		if err := os.MkdirAll(checkpointDir, 0755); err != nil {
+			return err
+		}
+		if err := oci.ImportCheckpoint(archivePath, checkpointDir); err != nil {
+			return fmt.Errorf("failed to unpack %s: %s", archivePath, err)
+		}
+	}
+
+	meta, err := oci.ReadCheckpointMetadata(checkpointDir)
+	if err != nil {
+		return fmt.Errorf("failed to read checkpoint metadata for %s: %s", containerID, err)
+	}
+
+	engineConfig := oci.NewConfig()
+	generator := generate.Generator{Config: &engineConfig.OciConfig.Spec}
+	engineConfig.SetBundlePath(meta.BundlePath)
+
+	configJSON := filepath.Join(checkpointDir, "config.json")
+	data, err := ioutil.ReadFile(configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", configJSON, err)
+	}
+	if err := json.Unmarshal(data, generator.Config); err != nil {
+		return fmt.Errorf("failed to parse %s: %s", configJSON, err)
+	}
+
+	if len(meta.Annotations) > 0 {
+		if engineConfig.OciConfig.Spec.Annotations == nil {
+			engineConfig.OciConfig.Spec.Annotations = make(map[string]string)
+		}
+		for k, v := range meta.Annotations {
+			engineConfig.OciConfig.Spec.Annotations[k] = v
+		}
+	}
+
+	engineConfig.RuntimeName = ociRuntimeName
+
+	runtime, err := oci.NewRuntime(ociRuntimeName, loadRuntimeBinaries())
+	if err != nil {
+		return err
+	}
+
+	return runtime.Restore(containerID, engineConfig, checkpointDir)
+}
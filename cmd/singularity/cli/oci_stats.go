@@ -0,0 +1,137 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/cgroups"
+)
+
+var (
+	statsFormat   string
+	statsNoStream bool
+	statsInterval time.Duration
+)
+
+func init() {
+	OciStatsCmd.Flags().SetInterspersed(false)
+	OciStatsCmd.Flags().StringVar(&statsFormat, "format", "table", "output format: table or json")
+	OciStatsCmd.Flags().BoolVar(&statsNoStream, "no-stream", false, "print one sample and exit, instead of streaming")
+	OciStatsCmd.Flags().DurationVar(&statsInterval, "interval", time.Second, "sampling interval between streamed updates")
+
+	OciCmd.AddCommand(OciStatsCmd)
+}
+
+// OciStatsCmd represents oci stats command
+var OciStatsCmd = &cobra.Command{
+	Args:                  cobra.ExactArgs(1),
+	DisableFlagsInUseLine: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ociStats(args[0]); err != nil {
+			sylog.Fatalf("%s", err)
+		}
+	},
+	Use:     "stats",
+	Short:   "oci stats",
+	Long:    "oci stats",
+	Example: "oci stats",
+}
+
+// statsSample is one point-in-time "oci stats" reading, with CPUPercent
+// filled in once there's a prior sample to compute a delta against.
+type statsSample struct {
+	CPUPercent   float64 `json:"cpu_percent"`
+	MemoryUsage  uint64  `json:"memory_usage"`
+	MemoryLimit  uint64  `json:"memory_limit"`
+	PIDs         uint64  `json:"pids"`
+	IOReadBytes  uint64  `json:"io_read_bytes"`
+	IOWriteBytes uint64  `json:"io_write_bytes"`
+}
+
+func ociStats(containerID string) error {
+	engineConfig, err := getEngineConfig(containerID)
+	if err != nil {
+		return err
+	}
+
+	cg, err := cgroups.NewFromPid(engineConfig.State.Pid)
+	if err != nil {
+		return fmt.Errorf("failed to find cgroup for container %s: %s", containerID, err)
+	}
+
+	var prev *cgroups.Stats
+	var prevTime time.Time
+
+	printTableHeader()
+
+	for {
+		stats, err := cg.Stats()
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+
+		sample := statsSample{
+			MemoryUsage:  stats.MemoryUsage,
+			MemoryLimit:  stats.MemoryLimit,
+			PIDs:         stats.PIDs,
+			IOReadBytes:  stats.IOReadBytes,
+			IOWriteBytes: stats.IOWriteBytes,
+		}
+		if prev != nil {
+			sample.CPUPercent = cpuPercent(prev.CPUUsage, stats.CPUUsage, now.Sub(prevTime), stats.CPULimit)
+		}
+
+		if err := printStatsSample(sample); err != nil {
+			return err
+		}
+
+		prev, prevTime = stats, now
+
+		if statsNoStream {
+			return nil
+		}
+		time.Sleep(statsInterval)
+	}
+}
+
+// cpuPercent reports the share of available CPU time the container
+// consumed between two samples taken elapsed apart, normalized by the
+// cgroup's CPU limit (falling back to the host's CPU count when the
+// container has no quota set) the way "docker stats" does.
+func cpuPercent(prev, cur time.Duration, elapsed time.Duration, cpuLimit float64) float64 {
+	if elapsed <= 0 || cur <= prev || cpuLimit <= 0 {
+		return 0
+	}
+	return float64(cur-prev) / float64(elapsed) / cpuLimit * 100
+}
+
+func printTableHeader() {
+	if statsFormat == "json" {
+		return
+	}
+	fmt.Printf("%-10s%-12s%-12s%-8s%-12s%-12s\n", "CPU %", "MEM USAGE", "MEM LIMIT", "PIDS", "IO READ", "IO WRITE")
+}
+
+func printStatsSample(s statsSample) error {
+	if statsFormat == "json" {
+		data, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("%-10.2f%-12d%-12d%-8d%-12d%-12d\n",
+		s.CPUPercent, s.MemoryUsage, s.MemoryLimit, s.PIDs, s.IOReadBytes, s.IOWriteBytes)
+	return nil
+}
@@ -0,0 +1,421 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cli
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/unix"
+)
+
+// websocketGUID is the fixed key the RFC 6455 handshake appends to
+// Sec-WebSocket-Key before hashing, to prove the response came from a
+// websocket-aware server.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Channel numbers for the streaming endpoint's binary websocket messages,
+// matching the kubelet "v4.channel.k8s.io" remotecommand subprotocol that
+// kubectl exec/attach speak: one connection, each message prefixed by a
+// single channel byte instead of separate SPDY streams.
+const (
+	streamChannelStdin  = 0
+	streamChannelStdout = 1
+	streamChannelStderr = 2
+	streamChannelError  = 3
+	streamChannelResize = 4
+)
+
+// resizeMessage is the JSON payload carried on streamChannelResize.
+type resizeMessage struct {
+	Width  uint
+	Height uint
+}
+
+// parseStreamAddr splits a "--stream-addr" value into the network and
+// address net.Listen wants. Only unix sockets are supported for now, which
+// covers every documented use of this flag.
+func parseStreamAddr(addr string) (network, address string, err error) {
+	u, err := url.Parse(addr)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid --stream-addr %q: %s", addr, err)
+	}
+	if u.Scheme != "unix" {
+		return "", "", fmt.Errorf("unsupported --stream-addr scheme %q (only unix:// is supported)", u.Scheme)
+	}
+	return "unix", u.Path, nil
+}
+
+// serveStream listens on addr, accepts the first client that completes a
+// websocket upgrade, and hands it to handler as a streamConn. It returns
+// once handler has run to completion.
+func serveStream(addr string, handler func(*streamConn) error) error {
+	network, address, err := parseStreamAddr(addr)
+	if err != nil {
+		return err
+	}
+
+	os.Remove(address)
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %s", addr, err)
+	}
+	defer l.Close()
+
+	var once sync.Once
+	done := make(chan error, 1)
+	finish := func(err error) {
+		once.Do(func() { done <- err })
+	}
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sc, err := upgradeWebsocket(w, r)
+			if err != nil {
+				sylog.Errorf("stream upgrade failed: %s", err)
+				return
+			}
+			defer sc.Close()
+			finish(handler(sc))
+		}),
+	}
+
+	go func() {
+		if err := srv.Serve(l); err != nil && err != http.ErrServerClosed {
+			finish(err)
+		}
+	}()
+
+	err = <-done
+	srv.Close()
+	return err
+}
+
+// upgradeWebsocket performs the RFC 6455 handshake over r's hijacked
+// connection and negotiates the "v4.channel.k8s.io" subprotocol.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*streamConn, error) {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		http.Error(w, "expected websocket upgrade", http.StatusBadRequest)
+		return nil, fmt.Errorf("request did not request a websocket upgrade")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "missing Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, fmt.Errorf("missing Sec-WebSocket-Key header")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	conn, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to hijack stream connection: %s", err)
+	}
+
+	h := sha1.New()
+	io.WriteString(h, key+websocketGUID)
+	accept := base64.StdEncoding.EncodeToString(h.Sum(nil))
+
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %s\r\n"+
+		"Sec-WebSocket-Protocol: v4.channel.k8s.io\r\n\r\n", accept)
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to complete websocket handshake: %s", err)
+	}
+
+	return &streamConn{conn: conn, rw: rw}, nil
+}
+
+// streamConn is one upgraded websocket connection, multiplexing the five
+// remotecommand channels onto channel-prefixed binary frames.
+type streamConn struct {
+	conn net.Conn
+	rw   *bufio.ReadWriter
+
+	writeMu sync.Mutex
+}
+
+func (sc *streamConn) Close() error {
+	return sc.conn.Close()
+}
+
+// writeFrame sends one unmasked binary frame carrying channel as its first
+// byte, followed by payload. Servers never mask frames, per RFC 6455.
+func (sc *streamConn) writeFrame(channel byte, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	length := uint64(len(payload) + 1)
+	header := []byte{0x82} // FIN set, binary opcode
+
+	switch {
+	case length <= 125:
+		header = append(header, byte(length))
+	case length <= 0xffff:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(length))
+		header = append(header, 126)
+		header = append(header, b...)
+	default:
+		b := make([]byte, 8)
+		binary.BigEndian.PutUint64(b, length)
+		header = append(header, 127)
+		header = append(header, b...)
+	}
+
+	if _, err := sc.rw.Write(header); err != nil {
+		return err
+	}
+	if _, err := sc.rw.Write([]byte{channel}); err != nil {
+		return err
+	}
+	if _, err := sc.rw.Write(payload); err != nil {
+		return err
+	}
+	return sc.rw.Flush()
+}
+
+// readFrame reads one client frame and returns its channel byte and
+// payload, skipping over ping/pong control frames. Client frames are
+// always masked, per RFC 6455.
+func (sc *streamConn) readFrame() (byte, []byte, error) {
+	for {
+		header := make([]byte, 2)
+		if _, err := io.ReadFull(sc.rw, header); err != nil {
+			return 0, nil, err
+		}
+
+		opcode := header[0] & 0x0f
+		masked := header[1]&0x80 != 0
+		length := uint64(header[1] & 0x7f)
+
+		switch length {
+		case 126:
+			b := make([]byte, 2)
+			if _, err := io.ReadFull(sc.rw, b); err != nil {
+				return 0, nil, err
+			}
+			length = uint64(binary.BigEndian.Uint16(b))
+		case 127:
+			b := make([]byte, 8)
+			if _, err := io.ReadFull(sc.rw, b); err != nil {
+				return 0, nil, err
+			}
+			length = binary.BigEndian.Uint64(b)
+		}
+
+		var maskKey [4]byte
+		if masked {
+			if _, err := io.ReadFull(sc.rw, maskKey[:]); err != nil {
+				return 0, nil, err
+			}
+		}
+
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(sc.rw, payload); err != nil {
+			return 0, nil, err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= maskKey[i%4]
+			}
+		}
+
+		switch opcode {
+		case 0x8: // close
+			return 0, nil, io.EOF
+		case 0x9, 0xa: // ping, pong: no payload we care about
+			continue
+		}
+
+		if length == 0 {
+			continue
+		}
+		return payload[0], payload[1:], nil
+	}
+}
+
+// pumpStream copies everything read from r onto sc as channel frames until r
+// returns an error (typically EOF once the writing side closes).
+func pumpStream(sc *streamConn, channel byte, r io.Reader) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if werr := sc.writeFrame(channel, buf[:n]); werr != nil {
+				return
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// attachOverStream serves an "oci attach" session at streamAddr instead of
+// local stdio, demultiplexing the monitor's persistent attach/control
+// sockets onto a kubelet-style streaming connection.
+func attachOverStream(streamAddr, attachSocket, controlSocket string) error {
+	return serveStream(streamAddr, func(sc *streamConn) error {
+		a, err := unix.Dial(attachSocket)
+		if err != nil {
+			return err
+		}
+		defer a.Close()
+
+		go pumpStream(sc, streamChannelStdout, a)
+
+		for {
+			channel, payload, err := sc.readFrame()
+			if err != nil {
+				return nil
+			}
+
+			switch channel {
+			case streamChannelStdin:
+				if _, err := a.Write(payload); err != nil {
+					return err
+				}
+			case streamChannelResize:
+				var sz resizeMessage
+				if err := json.Unmarshal(payload, &sz); err != nil {
+					sylog.Warningf("ignoring malformed resize frame: %s", err)
+					continue
+				}
+				if err := sendControlResize(controlSocket, sz.Height, sz.Width); err != nil {
+					sylog.Warningf("stream resize failed: %s", err)
+				}
+			}
+		}
+	})
+}
+
+// execOverStream redirects the calling process's stdio file descriptors to
+// pipes, runs fn (expected to drive a Runtime.Exec, which inherits stdio
+// the same way the local-stdio path does), and demultiplexes those pipes
+// onto a kubelet-style streaming connection at streamAddr. This lets a CRI
+// shim built on "oci exec --stream-addr" serve "kubectl exec" without the
+// apptainer CLI sitting in the pipe between kubelet and the container.
+func execOverStream(streamAddr string, fn func() error) error {
+	return serveStream(streamAddr, func(sc *streamConn) error {
+		stdin, restoreStdin, err := redirectStdio(0)
+		if err != nil {
+			return err
+		}
+		defer restoreStdin()
+
+		stdout, restoreStdout, err := redirectStdio(1)
+		if err != nil {
+			return err
+		}
+		defer restoreStdout()
+
+		stderr, restoreStderr, err := redirectStdio(2)
+		if err != nil {
+			return err
+		}
+		defer restoreStderr()
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			pumpStream(sc, streamChannelStdout, stdout)
+		}()
+		go func() {
+			defer wg.Done()
+			pumpStream(sc, streamChannelStderr, stderr)
+		}()
+
+		go func() {
+			for {
+				channel, payload, err := sc.readFrame()
+				if err != nil {
+					return
+				}
+				if channel == streamChannelStdin {
+					stdin.Write(payload)
+				}
+			}
+		}()
+
+		err = fn()
+
+		restoreStdout()
+		restoreStderr()
+		wg.Wait()
+		restoreStdin()
+
+		if err != nil {
+			sc.writeFrame(streamChannelError, []byte(err.Error()))
+		}
+		return err
+	})
+}
+
+// redirectStdio replaces fd (0, 1, or 2) with one end of a new OS pipe and
+// returns the end this process keeps for itself: the write end for fd 0
+// (so a caller can feed the redirected process's stdin), or the read end
+// for fd 1/2 (so a caller can drain its stdout/stderr). restore puts fd
+// back the way it was and closes the kept end; it is safe to call more
+// than once, since callers both run it inline once they're done with the
+// descriptor and defer it again as a safety net on early-return paths.
+func redirectStdio(fd int) (ours *os.File, restore func(), err error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create stdio pipe: %s", err)
+	}
+
+	saved, err := syscall.Dup(fd)
+	if err != nil {
+		r.Close()
+		w.Close()
+		return nil, nil, fmt.Errorf("failed to save fd %d: %s", fd, err)
+	}
+
+	var theirs *os.File
+	if fd == 0 {
+		theirs, ours = r, w
+	} else {
+		theirs, ours = w, r
+	}
+
+	if err := syscall.Dup2(int(theirs.Fd()), fd); err != nil {
+		theirs.Close()
+		ours.Close()
+		syscall.Close(saved)
+		return nil, nil, fmt.Errorf("failed to redirect fd %d: %s", fd, err)
+	}
+	theirs.Close()
+
+	var once sync.Once
+	restore = func() {
+		once.Do(func() {
+			ours.Close()
+			syscall.Dup2(saved, fd)
+			syscall.Close(saved)
+		})
+	}
+	return ours, restore, nil
+}
@@ -0,0 +1,48 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/util/exec"
+)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER from linux/prctl.h. It isn't
+// exposed by the syscall package, so it's invoked directly.
+const prSetChildSubreaper = 36
+
+// launchStarter marks smonitor as a child subreaper and then runs the
+// starter to completion. The starter double-forks to daemonize the
+// container and its own process exits once it has; because smonitor marked
+// itself a subreaper first, the kernel reparents the now-orphaned container
+// process to smonitor instead of to init, which is what lets waitForExit
+// actually reap it and observe its exit status afterwards.
+func launchStarter(starterBin, procName string, env []string, configPath string, slave *os.File, hasTerminal bool) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return fmt.Errorf("failed to mark smonitor as a child subreaper: %s", errno)
+	}
+
+	configData, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to read starter config: %s", err)
+	}
+
+	cmd, err := exec.PipeCommand(starterBin, []string{procName}, env, configData)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = slave
+	cmd.Stderr = slave
+	if hasTerminal {
+		cmd.Stdin = slave
+	}
+
+	return cmd.Run()
+}
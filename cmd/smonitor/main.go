@@ -0,0 +1,116 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Command smonitor is the conmon-style helper that launches the starter
+// itself (rather than being exec'd after the fact), so that when the
+// starter double-forks to daemonize the container, the orphaned container
+// process reparents to smonitor instead of to init: smonitor marks itself a
+// child subreaper before running the starter specifically so this happens,
+// which is what lets it later reap the container and record its exit
+// status without the CLI needing to stay alive to observe it.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kr/pty"
+	"github.com/sylabs/singularity/internal/pkg/instance/monitor"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// starterEnv collects repeated "-starter-env KEY=VALUE" flags into a plain
+// []string suitable for passing straight through to the starter.
+type starterEnv []string
+
+func (e *starterEnv) String() string { return strings.Join(*e, ",") }
+
+func (e *starterEnv) Set(value string) error {
+	*e = append(*e, value)
+	return nil
+}
+
+func main() {
+	containerID := flag.String("container-id", "", "container ID this monitor is attached to")
+	instanceDir := flag.String("instance-dir", "", "instance directory holding the sockets/exit file")
+	logPath := flag.String("log-path", "", "base path for the rotated CRI-format container log (default: container.log inside instance-dir)")
+	logSizeMax := flag.Int64("log-size-max", monitor.DefaultLogSizeMax, "rotate the container log once it exceeds this many bytes")
+	healthcheckCmd := flag.String("healthcheck-cmd", "", "shell command to run periodically inside the container to check its health (disabled when empty)")
+	healthcheckInterval := flag.Duration("healthcheck-interval", monitor.DefaultHealthcheckInterval, "how often to run the healthcheck command")
+	healthcheckTimeout := flag.Duration("healthcheck-timeout", monitor.DefaultHealthcheckTimeout, "time allowed for one healthcheck run before it counts as a failure")
+	healthcheckRetries := flag.Int("healthcheck-retries", monitor.DefaultHealthcheckRetries, "consecutive failures required before the container is reported unhealthy")
+	healthcheckStartPeriod := flag.Duration("healthcheck-start-period", 0, "initial grace period during which failures don't count towards healthcheck-retries")
+	starterBin := flag.String("starter-bin", "", "starter binary for smonitor to launch as its own child")
+	starterProcName := flag.String("starter-proc-name", "", "process title to give the starter")
+	starterConfigPath := flag.String("starter-config-path", "", "path to the marshaled engine config to pipe to the starter")
+	starterTTY := flag.Bool("starter-tty", false, "also wire the container's stdin to its console, for bundles with a terminal")
+	var starterEnvFlag starterEnv
+	flag.Var(&starterEnvFlag, "starter-env", "KEY=VALUE environment variable to set for the starter (repeatable)")
+	flag.Parse()
+
+	if *containerID == "" || *instanceDir == "" {
+		sylog.Fatalf("smonitor requires -container-id and -instance-dir")
+	}
+	if *starterBin == "" || *starterConfigPath == "" {
+		sylog.Fatalf("smonitor requires -starter-bin and -starter-config-path")
+	}
+
+	// fd 3 is the container's master PTY or stdout pipe, fd 4 is the slave
+	// end wired to the starter's own stdio until it daemonizes, and fd 5 is
+	// the write end of the pipe the CLI blocks reading to learn whether the
+	// starter came up cleanly and the attach/control sockets are listening.
+	// All three are handed down by the CLI; see
+	// startMonitor in runtime_starter.go for the matching write side.
+	console := os.NewFile(3, "console")
+	if console == nil {
+		sylog.Fatalf("no console file descriptor inherited on fd 3")
+	}
+	slave := os.NewFile(4, "starter-stdio")
+	if slave == nil {
+		sylog.Fatalf("no starter stdio file descriptor inherited on fd 4")
+	}
+	ready := os.NewFile(5, "ready")
+	if ready == nil {
+		sylog.Fatalf("no ready file descriptor inherited on fd 5")
+	}
+
+	if err := launchStarter(*starterBin, *starterProcName, starterEnvFlag, *starterConfigPath, slave, *starterTTY); err != nil {
+		fmt.Fprintf(ready, "ERR: %s\n", err)
+		ready.Close()
+		sylog.Fatalf("%s", err)
+	}
+
+	m := monitor.New(*containerID, *instanceDir, console)
+	m.LogBasePath = *logPath
+	m.LogSizeMax = *logSizeMax
+	if *starterTTY {
+		// Only a PTY master, not a plain stdout pipe, can be resized: wire
+		// this up only for terminal bundles, the same condition the CLI
+		// used to decide whether to wire the container's stdin up at all.
+		m.Resize = func(rows, cols uint) error {
+			return pty.Setsize(console, &pty.Winsize{Rows: uint16(rows), Cols: uint16(cols)})
+		}
+	}
+	if *healthcheckCmd != "" {
+		m.Healthcheck = &monitor.HealthcheckConfig{
+			Cmd:         *healthcheckCmd,
+			Interval:    *healthcheckInterval,
+			Timeout:     *healthcheckTimeout,
+			Retries:     *healthcheckRetries,
+			StartPeriod: *healthcheckStartPeriod,
+		}
+	}
+	if err := m.Serve(); err != nil {
+		fmt.Fprintf(ready, "ERR: %s\n", err)
+		ready.Close()
+		sylog.Fatalf("%s", err)
+	}
+	fmt.Fprintln(ready, "OK")
+	ready.Close()
+
+	waitForExit(m, console)
+}
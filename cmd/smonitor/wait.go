@@ -0,0 +1,37 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package main
+
+import (
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/sylabs/singularity/internal/pkg/instance/monitor"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+)
+
+// waitForExit blocks until the container's init process exits, then writes
+// its exit status via the monitor and exits the monitor process itself.
+// launchStarter already marked smonitor a child subreaper before running
+// the starter and waited for the starter's own process to be reaped, so by
+// this point the container - reparented to smonitor once the starter
+// exited - is smonitor's only remaining child, and Wait4(-1, ...) is
+// guaranteed to reap it rather than some unrelated process.
+func waitForExit(m *monitor.Monitor, console io.Closer) {
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(-1, &ws, 0, nil); err != nil {
+		sylog.Fatalf("failed to wait for container process: %s", err)
+	}
+
+	console.Close()
+
+	if err := m.WriteExitStatus(ws.ExitStatus()); err != nil {
+		sylog.Fatalf("failed to record exit status: %s", err)
+	}
+
+	os.Exit(0)
+}
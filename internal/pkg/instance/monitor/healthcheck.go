@@ -0,0 +1,222 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/oci"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/unix"
+)
+
+const (
+	// HealthcheckResultsName is the name of the ring-buffer file of past
+	// healthcheck results, inside the instance directory.
+	HealthcheckResultsName = "healthcheck.json"
+	// HealthStatusName is the name of the file the current health status is
+	// written to, inside the instance directory.
+	HealthStatusName = "health-status"
+
+	maxHealthcheckResults = 5
+	maxHealthcheckOutput  = 4096
+)
+
+// Health status values, mirroring Docker/Podman's HEALTHCHECK lifecycle.
+const (
+	HealthStatusStarting  = "starting"
+	HealthStatusHealthy   = "healthy"
+	HealthStatusUnhealthy = "unhealthy"
+)
+
+// Default healthcheck parameters. Defined once in the oci package, which
+// applies them when parsing a bundle's healthcheck annotations, and
+// re-exported here so smonitor's flag defaults can't drift from them.
+const (
+	DefaultHealthcheckInterval = oci.DefaultHealthcheckInterval
+	DefaultHealthcheckTimeout  = oci.DefaultHealthcheckTimeout
+	DefaultHealthcheckRetries  = oci.DefaultHealthcheckRetries
+)
+
+// HealthcheckConfig is the healthcheck the monitor schedules against the
+// running container, derived from the bundle's healthcheck annotations by
+// the CLI/engine and passed down as smonitor flags.
+type HealthcheckConfig struct {
+	Cmd         string
+	Interval    time.Duration
+	Timeout     time.Duration
+	Retries     int
+	StartPeriod time.Duration
+}
+
+// HealthcheckResult is one ring-buffer entry recorded after running the
+// healthcheck command.
+type HealthcheckResult struct {
+	Start    time.Time
+	Duration time.Duration
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// runHealthchecks periodically runs the configured healthcheck command
+// against the container for the lifetime of the monitor, recording results
+// and updating the health status file. It is started from Serve when
+// m.Healthcheck is set, and never returns.
+func (m *Monitor) runHealthchecks() {
+	hc := m.Healthcheck
+
+	if err := WriteHealthStatus(m.InstanceDir, HealthStatusStarting); err != nil {
+		sylog.Warningf("failed to write health status: %s", err)
+	}
+
+	if hc.StartPeriod > 0 {
+		time.Sleep(hc.StartPeriod)
+	}
+
+	failures := 0
+	ticker := time.NewTicker(hc.Interval)
+	defer ticker.Stop()
+
+	for {
+		result := RunHealthcheck(m.ContainerID, hc.Cmd, hc.Timeout)
+
+		if err := AppendHealthcheckResult(m.InstanceDir, result); err != nil {
+			sylog.Warningf("failed to record healthcheck result: %s", err)
+		}
+
+		if result.ExitCode == 0 {
+			failures = 0
+		} else {
+			failures++
+		}
+
+		status := HealthStatusHealthy
+		if failures >= hc.Retries {
+			status = HealthStatusUnhealthy
+		}
+		if err := WriteHealthStatus(m.InstanceDir, status); err != nil {
+			sylog.Warningf("failed to write health status: %s", err)
+		}
+
+		<-ticker.C
+	}
+}
+
+// RunHealthcheck execs "singularity oci exec <containerID> sh -c <cmd>",
+// capturing its output and exit code within timeout. It backs both the
+// monitor's periodic scheduler and "oci healthcheck"'s on-demand runs, so
+// both paths record identical ring-buffer entries.
+func RunHealthcheck(containerID, cmd string, timeout time.Duration) HealthcheckResult {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	singularityBin := buildcfg.BINDIR + "/singularity"
+	c := exec.CommandContext(ctx, singularityBin, "oci", "exec", containerID, "sh", "-c", cmd)
+
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+
+	start := time.Now()
+	err := c.Run()
+	duration := time.Since(start)
+
+	exitCode := 0
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		} else {
+			exitCode = -1
+		}
+	}
+
+	return HealthcheckResult{
+		Start:    start,
+		Duration: duration,
+		ExitCode: exitCode,
+		Stdout:   truncateOutput(stdout.String()),
+		Stderr:   truncateOutput(stderr.String()),
+	}
+}
+
+func truncateOutput(s string) string {
+	if len(s) <= maxHealthcheckOutput {
+		return s
+	}
+	return s[len(s)-maxHealthcheckOutput:]
+}
+
+// AppendHealthcheckResult appends result to the ring buffer of past
+// healthcheck results recorded for the container, keeping only the most
+// recent maxHealthcheckResults entries.
+func AppendHealthcheckResult(instanceDir string, result HealthcheckResult) error {
+	path := filepath.Join(instanceDir, HealthcheckResultsName)
+
+	var results []HealthcheckResult
+	if data, err := ioutil.ReadFile(path); err == nil {
+		json.Unmarshal(data, &results)
+	}
+
+	results = append(results, result)
+	if len(results) > maxHealthcheckResults {
+		results = results[len(results)-maxHealthcheckResults:]
+	}
+
+	data, err := json.MarshalIndent(results, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return unix.WriteFileAtomic(path, data, 0644)
+}
+
+// ReadHealthcheckResults returns the ring buffer of past healthcheck
+// results recorded for the container, oldest first.
+func ReadHealthcheckResults(instanceDir string) ([]HealthcheckResult, error) {
+	data, err := ioutil.ReadFile(filepath.Join(instanceDir, HealthcheckResultsName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var results []HealthcheckResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// WriteHealthStatus persists the container's current health status so "oci
+// state" and "oci healthcheck" can report it without talking to the
+// monitor directly.
+func WriteHealthStatus(instanceDir, status string) error {
+	return unix.WriteFileAtomic(filepath.Join(instanceDir, HealthStatusName), []byte(status), 0644)
+}
+
+// ReadHealthStatus reads back the status written by WriteHealthStatus. ok
+// is false when no healthcheck has ever run for the container.
+func ReadHealthStatus(instanceDir string) (status string, ok bool) {
+	data, err := ioutil.ReadFile(filepath.Join(instanceDir, HealthStatusName))
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
@@ -0,0 +1,186 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package monitor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogFileName is the base name of the CRI-format log file the monitor
+// writes container output to, inside the instance directory.
+const LogFileName = "container.log"
+
+// DefaultLogSizeMax is the size, in bytes, an active log file is allowed to
+// reach before the monitor rotates it out.
+const DefaultLogSizeMax = 10 * 1024 * 1024
+
+// logWriter writes container output to a CRI-format log file (the format
+// kubelet/conmon use): one line per write of the form
+//
+//	<RFC3339Nano timestamp> <stream> <P|F> <payload>\n
+//
+// where stream is "stdout" or "stderr" and P/F marks whether the write was
+// a partial line (cut short by the read buffer) or a complete one. The
+// active file is rotated to "<path>.N" and gzip-compressed once it exceeds
+// maxSize.
+type logWriter struct {
+	mu      sync.Mutex
+	path    string
+	stream  string
+	maxSize int64
+
+	file *os.File
+	size int64
+}
+
+// newLogWriter opens (creating if necessary) the CRI log file at path for
+// the given stream ("stdout" or "stderr"), rotating at maxSize bytes.
+func newLogWriter(path, stream string, maxSize int64) (*logWriter, error) {
+	if maxSize <= 0 {
+		maxSize = DefaultLogSizeMax
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &logWriter{
+		path:    path,
+		stream:  stream,
+		maxSize: maxSize,
+		file:    f,
+		size:    info.Size(),
+	}, nil
+}
+
+// Write implements io.Writer, logging p as one or more CRI-format lines. A
+// chunk that doesn't end in a newline (because it was cut short by the
+// read buffer upstream) is logged as partial ("P") so a log reader knows to
+// stitch it together with the next line for the same stream.
+func (w *logWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().Format(time.RFC3339Nano)
+	lines := bytes.Split(p, []byte("\n"))
+
+	for i, line := range lines {
+		last := i == len(lines)-1
+		if last && len(line) == 0 {
+			// trailing newline produced an empty final element
+			continue
+		}
+
+		tag := byte('F')
+		if last {
+			tag = 'P'
+		}
+
+		entry := fmt.Sprintf("%s %s %c %s\n", now, w.stream, tag, line)
+		if err := w.rotateIfNeeded(int64(len(entry))); err != nil {
+			return 0, err
+		}
+
+		n, err := w.file.WriteString(entry)
+		w.size += int64(n)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// rotateIfNeeded rotates the active log file out to "<path>.1" (shifting
+// existing numbered segments up and gzip-compressing them) when appending
+// next would exceed maxSize.
+func (w *logWriter) rotateIfNeeded(next int64) error {
+	if w.size+next <= w.maxSize {
+		return nil
+	}
+
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+
+	if err := rotateSegments(w.path); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file = f
+	w.size = 0
+	return nil
+}
+
+// rotateSegments shifts the already-compressed segments path.N.gz to
+// path.N+1.gz (path.4.gz overwrites path.5.gz, so at most five segments
+// are kept), then gzip-compresses the active file at path into path.1.gz.
+func rotateSegments(path string) error {
+	const maxSegments = 5
+
+	for n := maxSegments - 1; n >= 1; n-- {
+		from := fmt.Sprintf("%s.%d.gz", path, n)
+		to := fmt.Sprintf("%s.%d.gz", path, n+1)
+		if _, err := os.Stat(from); err == nil {
+			os.Rename(from, to)
+		}
+	}
+
+	return compressFile(path, fmt.Sprintf("%s.1.gz", path))
+}
+
+// compressFile gzip-compresses src into dst, removing src afterwards.
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	return os.Remove(src)
+}
+
+// Close closes the underlying log file.
+func (w *logWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Close()
+}
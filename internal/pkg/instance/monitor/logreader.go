@@ -0,0 +1,212 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package monitor
+
+import (
+	"bufio"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LogEntry is one logical, fully stitched-together line of container
+// output read back from the CRI-format log.
+type LogEntry struct {
+	Time    time.Time
+	Stream  string
+	Payload string
+}
+
+// ReadLog returns every log entry recorded for the container at basePath,
+// oldest first, across all rotated (and gzip-compressed) segments plus the
+// active file. Consecutive partial ("P") writes for the same stream are
+// stitched back into a single logical entry.
+func ReadLog(basePath string) ([]LogEntry, error) {
+	paths, err := logSegmentPaths(basePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []LogEntry
+
+	for _, p := range paths {
+		lines, err := readLogLines(p)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", p, err)
+		}
+		entries = append(entries, lines...)
+	}
+
+	return stitchPartials(entries), nil
+}
+
+// logSegmentPaths returns the rotated segments of basePath (oldest first)
+// followed by the active log file itself.
+func logSegmentPaths(basePath string) ([]string, error) {
+	type segment struct {
+		n    int
+		path string
+	}
+
+	var segments []segment
+
+	matches, err := filepath.Glob(basePath + ".*.gz")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range matches {
+		var n int
+		if _, err := fmt.Sscanf(m, basePath+".%d.gz", &n); err == nil {
+			segments = append(segments, segment{n: n, path: m})
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i].n > segments[j].n })
+
+	paths := make([]string, 0, len(segments)+1)
+	for _, s := range segments {
+		paths = append(paths, s.path)
+	}
+	if _, err := os.Stat(basePath); err == nil {
+		paths = append(paths, basePath)
+	}
+
+	return paths, nil
+}
+
+func readLogLines(path string) ([]LogEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	var entries []LogEntry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		entry, ok := parseLogLine(scanner.Text())
+		if ok {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, scanner.Err()
+}
+
+// parseLogLine parses one "<timestamp> <stream> <P|F> <payload>" line. The
+// tag itself is dropped: a partial ("P") line instead gets partialMarker
+// prefixed to its Payload, which stitchPartials recognizes and strips.
+func parseLogLine(line string) (LogEntry, bool) {
+	parts := strings.SplitN(line, " ", 4)
+	if len(parts) != 4 {
+		return LogEntry{}, false
+	}
+
+	ts, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return LogEntry{}, false
+	}
+
+	payload := parts[3]
+	if parts[2] == "P" {
+		payload = partialMarker + payload
+	}
+
+	return LogEntry{Time: ts, Stream: parts[1], Payload: payload}, true
+}
+
+// partialMarker prefixes the payload of a still-open logical line so
+// stitchPartials can recognize and merge it with what follows.
+const partialMarker = "\x00partial\x00"
+
+// stitchPartials merges consecutive entries for the same stream that were
+// written as partial lines (cut short by the monitor's read buffer) back
+// into one logical entry, keeping the timestamp of the first fragment.
+func stitchPartials(entries []LogEntry) []LogEntry {
+	var out []LogEntry
+	var pending *LogEntry
+
+	for _, e := range entries {
+		partial := strings.HasPrefix(e.Payload, partialMarker)
+		payload := strings.TrimPrefix(e.Payload, partialMarker)
+
+		if pending != nil && pending.Stream == e.Stream {
+			pending.Payload += payload
+			if !partial {
+				out = append(out, *pending)
+				pending = nil
+			}
+			continue
+		}
+
+		if pending != nil {
+			out = append(out, *pending)
+			pending = nil
+		}
+
+		if partial {
+			p := e
+			p.Payload = payload
+			pending = &p
+			continue
+		}
+
+		out = append(out, e)
+	}
+
+	if pending != nil {
+		out = append(out, *pending)
+	}
+
+	return out
+}
+
+// since filters entries to those at or after t; a zero Time disables
+// filtering.
+func since(entries []LogEntry, t time.Time) []LogEntry {
+	if t.IsZero() {
+		return entries
+	}
+
+	var out []LogEntry
+	for _, e := range entries {
+		if !e.Time.Before(t) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// tail keeps only the last n entries (n <= 0 keeps everything).
+func tail(entries []LogEntry, n int) []LogEntry {
+	if n <= 0 || len(entries) <= n {
+		return entries
+	}
+	return entries[len(entries)-n:]
+}
+
+// Since, Tail are exported wrappers so CLI code can post-process ReadLog's
+// result without reaching into unexported helpers.
+func Since(entries []LogEntry, t time.Time) []LogEntry { return since(entries, t) }
+func Tail(entries []LogEntry, n int) []LogEntry        { return tail(entries, n) }
@@ -0,0 +1,249 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package monitor implements the conmon-style container monitor (smonitor)
+// that takes ownership of the container's PTY/pipe once the starter has
+// daemonized the container process. It survives the CLI process exiting,
+// serves the attach/control sockets used by "oci attach", and records the
+// final exit status so the CLI no longer needs to stay alive to observe it.
+package monitor
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/unix"
+	"github.com/sylabs/singularity/pkg/ociruntime"
+)
+
+const (
+	// AttachSocketName is the name of the persistent attach socket created
+	// by the monitor inside the instance directory.
+	AttachSocketName = "attach.sock"
+	// ControlSocketName is the name of the persistent control socket
+	// created by the monitor inside the instance directory.
+	ControlSocketName = "control.sock"
+	// ExitFileName is the file the monitor writes the container exit code
+	// to once the container process terminates.
+	ExitFileName = "exit"
+)
+
+// Monitor owns the container's master PTY (or stdio pipes) and multiplexes
+// it to any number of attach clients over a persistent unix socket, while
+// accepting resize/control messages on a separate socket.
+type Monitor struct {
+	ContainerID string
+	InstanceDir string
+
+	// Console is the container's master PTY or stdout pipe, handed down
+	// by the CLI once the starter has daemonized the container.
+	Console io.ReadWriteCloser
+	// Resize is called with the new terminal size whenever a control
+	// message is received on the control socket.
+	Resize func(rows, cols uint) error
+	// LogSizeMax is the size, in bytes, the CRI-format log is allowed to
+	// grow to before being rotated. Zero means DefaultLogSizeMax.
+	LogSizeMax int64
+	// LogBasePath overrides where the CRI-format log is rooted. Left
+	// empty, it defaults to LogFileName inside InstanceDir.
+	LogBasePath string
+	// Healthcheck, when set, is scheduled by Serve as a goroutine that
+	// periodically checks the container's health for the monitor's
+	// lifetime.
+	Healthcheck *HealthcheckConfig
+
+	mutex   sync.Mutex
+	clients []io.WriteCloser
+	log     *logWriter
+}
+
+// New returns a Monitor ready to Serve the attach/control sockets for
+// containerID inside instanceDir.
+func New(containerID, instanceDir string, console io.ReadWriteCloser) *Monitor {
+	return &Monitor{
+		ContainerID: containerID,
+		InstanceDir: instanceDir,
+		Console:     console,
+	}
+}
+
+// LogPath returns the path of the CRI-format log file inside instanceDir.
+func LogPath(instanceDir string) string {
+	return filepath.Join(instanceDir, LogFileName)
+}
+
+// LogPath returns the path of the CRI-format log file for this monitor,
+// honoring LogBasePath when set.
+func (m *Monitor) LogPath() string {
+	if m.LogBasePath != "" {
+		return m.LogBasePath
+	}
+	return LogPath(m.InstanceDir)
+}
+
+// AttachSocketPath returns the path of the persistent attach socket inside
+// instanceDir, usable by both the monitor and any CLI invocation that needs
+// to reach it without constructing a Monitor of its own.
+func AttachSocketPath(instanceDir string) string {
+	return filepath.Join(instanceDir, AttachSocketName)
+}
+
+// ControlSocketPath returns the path of the persistent control socket
+// inside instanceDir.
+func ControlSocketPath(instanceDir string) string {
+	return filepath.Join(instanceDir, ControlSocketName)
+}
+
+// ExitFilePath returns the path of the exit status file inside instanceDir.
+func ExitFilePath(instanceDir string) string {
+	return filepath.Join(instanceDir, ExitFileName)
+}
+
+// AttachSocketPath returns the path of the attach socket for this monitor.
+func (m *Monitor) AttachSocketPath() string {
+	return AttachSocketPath(m.InstanceDir)
+}
+
+// ControlSocketPath returns the path of the control socket for this monitor.
+func (m *Monitor) ControlSocketPath() string {
+	return ControlSocketPath(m.InstanceDir)
+}
+
+// ExitFilePath returns the path of the exit status file for this monitor.
+func (m *Monitor) ExitFilePath() string {
+	return ExitFilePath(m.InstanceDir)
+}
+
+// Serve starts accepting connections on the attach and control sockets and
+// begins copying container output to every connected attach client. It
+// returns once both listeners are ready to accept connections; the actual
+// copy/accept loops run in background goroutines for the lifetime of the
+// monitor process.
+func (m *Monitor) Serve() error {
+	attachListener, err := net.Listen("unix", m.AttachSocketPath())
+	if err != nil {
+		return fmt.Errorf("failed to create attach socket: %s", err)
+	}
+
+	controlListener, err := net.Listen("unix", m.ControlSocketPath())
+	if err != nil {
+		attachListener.Close()
+		return fmt.Errorf("failed to create control socket: %s", err)
+	}
+
+	log, err := newLogWriter(m.LogPath(), "stdout", m.LogSizeMax)
+	if err != nil {
+		attachListener.Close()
+		controlListener.Close()
+		return fmt.Errorf("failed to create container log: %s", err)
+	}
+	m.log = log
+
+	go m.serveAttach(attachListener)
+	go m.serveControl(controlListener)
+	go m.broadcastOutput()
+	if m.Healthcheck != nil {
+		go m.runHealthchecks()
+	}
+
+	return nil
+}
+
+func (m *Monitor) serveAttach(l net.Listener) {
+	defer l.Close()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		m.mutex.Lock()
+		m.clients = append(m.clients, c)
+		m.mutex.Unlock()
+
+		go func(c net.Conn) {
+			io.Copy(m.Console, c)
+		}(c)
+	}
+}
+
+func (m *Monitor) serveControl(l net.Listener) {
+	defer l.Close()
+
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		ctrl := &ociruntime.Control{}
+		if err := json.NewDecoder(c).Decode(ctrl); err != nil {
+			sylog.Warningf("failed to decode control message: %s", err)
+			c.Close()
+			continue
+		}
+		c.Close()
+
+		if ctrl.ConsoleSize != nil && m.Resize != nil {
+			if err := m.Resize(ctrl.ConsoleSize.Height, ctrl.ConsoleSize.Width); err != nil {
+				sylog.Warningf("failed to resize console: %s", err)
+			}
+		}
+	}
+}
+
+// broadcastOutput copies everything read from the container console to all
+// currently attached clients and to the CRI-format log, so a client
+// attaching after output has been produced only misses what was written
+// before it connected (it can always replay the full history from the log
+// via "oci logs").
+func (m *Monitor) broadcastOutput() {
+	buf := make([]byte, 4096)
+
+	for {
+		n, err := m.Console.Read(buf)
+		if n > 0 {
+			if _, werr := m.log.Write(buf[:n]); werr != nil {
+				sylog.Warningf("failed to write container log: %s", werr)
+			}
+
+			m.mutex.Lock()
+			for _, c := range m.clients {
+				c.Write(buf[:n])
+			}
+			m.mutex.Unlock()
+		}
+		if err != nil {
+			m.log.Close()
+			return
+		}
+	}
+}
+
+// WriteExitStatus persists the container's exit status to the exit file so
+// exitContainer can pick it up without needing the sync socket to still be
+// open, and so repeated "oci attach" calls after the container stopped can
+// still report the correct code.
+func (m *Monitor) WriteExitStatus(exitCode int) error {
+	return unix.WriteFileAtomic(m.ExitFilePath(), []byte(strconv.Itoa(exitCode)), 0644)
+}
+
+// ReadExitStatus reads back the exit status written by WriteExitStatus, for
+// use by the CLI after it reattaches to a detached monitor.
+func ReadExitStatus(instanceDir string) (int, error) {
+	data, err := ioutil.ReadFile(ExitFilePath(instanceDir))
+	if err != nil {
+		return -1, err
+	}
+	return strconv.Atoi(string(data))
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+)
+
+// AnnotationCheckpointed marks a checkpoint's metadata, and the container
+// restored from it, as having gone through "oci checkpoint"/"oci restore".
+const AnnotationCheckpointed = "io.sylabs.checkpointed"
+
+// checkpointMetadataName is the sidecar file written alongside the bundle's
+// config.json inside a checkpoint directory, recording what's needed to
+// restore it later.
+const checkpointMetadataName = "spec.dump"
+
+// CheckpointOptions controls how Checkpoint dumps a running container and
+// how the resulting checkpoint is packaged, mirroring the surface Podman
+// exposes on top of CRIU.
+type CheckpointOptions struct {
+	// Export packs the checkpoint directory into a tar archive at this
+	// path once the dump succeeds. Left empty, the directory is kept as
+	// is.
+	Export string
+	// LeaveRunning keeps the container's processes running after the dump
+	// instead of CRIU's default of killing them.
+	LeaveRunning bool
+	// TCPEstablished lets CRIU checkpoint/restore established TCP
+	// connections instead of refusing to dump them.
+	TCPEstablished bool
+	// Keep leaves the checkpoint directory behind after packing it into
+	// Export instead of removing it.
+	Keep bool
+}
+
+// CheckpointMetadata is the content of a checkpoint's spec.dump sidecar,
+// read back by "oci restore" to repopulate the engine config.
+type CheckpointMetadata struct {
+	ContainerID string
+	BundlePath  string
+	Annotations map[string]string
+}
+
+// writeCheckpointMetadata copies the bundle's config.json into imagePath
+// and writes the spec.dump sidecar recording where the checkpoint came
+// from, so "oci restore" can repopulate the engine config from imagePath
+// alone.
+func writeCheckpointMetadata(imagePath, containerID string, engineConfig *EngineConfig) error {
+	bundlePath := engineConfig.GetBundlePath()
+
+	configJSON := filepath.Join(bundlePath, "config.json")
+	data, err := ioutil.ReadFile(configJSON)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %s", configJSON, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(imagePath, "config.json"), data, 0644); err != nil {
+		return err
+	}
+
+	meta := CheckpointMetadata{
+		ContainerID: containerID,
+		BundlePath:  bundlePath,
+		Annotations: map[string]string{AnnotationCheckpointed: "true"},
+	}
+
+	metaData, err := json.MarshalIndent(meta, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(imagePath, checkpointMetadataName), metaData, 0644)
+}
+
+// ReadCheckpointMetadata reads back the spec.dump sidecar written by
+// writeCheckpointMetadata.
+func ReadCheckpointMetadata(imagePath string) (*CheckpointMetadata, error) {
+	data, err := ioutil.ReadFile(filepath.Join(imagePath, checkpointMetadataName))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta CheckpointMetadata
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+// exportCheckpoint packs dir into a tar archive at dest.
+func exportCheckpoint(dir, dest string) error {
+	cmd := osexec.Command("tar", "-C", filepath.Dir(dir), "-cf", dest, filepath.Base(dir))
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// ImportCheckpoint unpacks the tar archive at archivePath into dir, which
+// must already exist.
+func ImportCheckpoint(archivePath, dir string) error {
+	cmd := osexec.Command("tar", "-C", dir, "--strip-components=1", "-xf", archivePath)
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
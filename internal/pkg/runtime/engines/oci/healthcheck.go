@@ -0,0 +1,83 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"strconv"
+	"time"
+)
+
+// Healthcheck annotation keys. These mirror the Docker HEALTHCHECK fields
+// that Podman reads off an OCI image and carries forward as bundle
+// annotations.
+const (
+	AnnotationHealthcheckCmd         = "org.opencontainers.image.healthcheck.cmd"
+	AnnotationHealthcheckInterval    = "org.opencontainers.image.healthcheck.interval"
+	AnnotationHealthcheckTimeout     = "org.opencontainers.image.healthcheck.timeout"
+	AnnotationHealthcheckRetries     = "org.opencontainers.image.healthcheck.retries"
+	AnnotationHealthcheckStartPeriod = "org.opencontainers.image.healthcheck.start-period"
+
+	// AnnotationHealthStatus is the annotation "oci state" fills in with the
+	// status the monitor last recorded for the container.
+	AnnotationHealthStatus = "io.sylabs.healthcheck.status"
+)
+
+// Default healthcheck parameters, matching Docker's HEALTHCHECK defaults.
+const (
+	DefaultHealthcheckInterval = 30 * time.Second
+	DefaultHealthcheckTimeout  = 30 * time.Second
+	DefaultHealthcheckRetries  = 3
+)
+
+// HealthcheckSpec is the parsed form of a bundle's healthcheck annotations,
+// used to schedule periodic checks inside the monitor.
+type HealthcheckSpec struct {
+	Cmd         string
+	Interval    time.Duration
+	Timeout     time.Duration
+	Retries     int
+	StartPeriod time.Duration
+}
+
+// HealthcheckSpecFromAnnotations extracts a HealthcheckSpec from bundle
+// annotations, applying Docker-compatible defaults to any field left unset.
+// ok is false when the bundle carries no healthcheck command at all.
+func HealthcheckSpecFromAnnotations(annotations map[string]string) (spec HealthcheckSpec, ok bool) {
+	cmd := annotations[AnnotationHealthcheckCmd]
+	if cmd == "" {
+		return HealthcheckSpec{}, false
+	}
+
+	spec = HealthcheckSpec{
+		Cmd:      cmd,
+		Interval: DefaultHealthcheckInterval,
+		Timeout:  DefaultHealthcheckTimeout,
+		Retries:  DefaultHealthcheckRetries,
+	}
+
+	if v := annotations[AnnotationHealthcheckInterval]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			spec.Interval = d
+		}
+	}
+	if v := annotations[AnnotationHealthcheckTimeout]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			spec.Timeout = d
+		}
+	}
+	if v := annotations[AnnotationHealthcheckStartPeriod]; v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			spec.StartPeriod = d
+		}
+	}
+	if v := annotations[AnnotationHealthcheckRetries]; v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			spec.Retries = n
+		}
+	}
+
+	return spec, true
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/sylabs/singularity/internal/pkg/util/unix"
+)
+
+const (
+	// AnnotationPaused is the annotation "oci state" fills in to report that
+	// a container is currently frozen via "oci pause".
+	AnnotationPaused = "io.sylabs.paused"
+
+	// pausedStateName is the marker file recording whether "oci pause" was
+	// last run against a container, inside its instance directory.
+	pausedStateName = "paused"
+)
+
+// WritePausedState persists whether containerID's instance directory is
+// frozen, so "oci state" can report it without talking to the runtime
+// backend directly.
+func WritePausedState(instanceDir string, paused bool) error {
+	value := "false"
+	if paused {
+		value = "true"
+	}
+	return unix.WriteFileAtomic(filepath.Join(instanceDir, pausedStateName), []byte(value), 0644)
+}
+
+// IsPaused reads back the state written by WritePausedState. It returns
+// false when the container has never been paused.
+func IsPaused(instanceDir string) bool {
+	data, err := ioutil.ReadFile(filepath.Join(instanceDir, pausedStateName))
+	if err != nil {
+		return false
+	}
+	return string(data) == "true"
+}
@@ -0,0 +1,93 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"fmt"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+)
+
+// RuntimeBinary is the binary path and default arguments configured for a
+// named runtime backend in singularity.conf's [oci runtimes] table.
+type RuntimeBinary struct {
+	Path string
+	Args []string
+}
+
+// StarterRuntimeName is the name of the built-in runtime backend that
+// launches containers through Singularity's own starter binary. It's the
+// default backend. The runc and crun backends are always registered too,
+// running the binary of that name from PATH unless singularity.conf's
+// [oci runtimes] table gives another path for them.
+const StarterRuntimeName = "starter"
+
+// Runtime abstracts the OCI-level operations ociCreate/ociStart/ociKill/
+// ociDelete/ociExec/ociState need, so a bundle can be driven either by
+// Singularity's own starter or by an external OCI-compliant runtime such as
+// runc or crun. The EngineConfig persisted for a container records which
+// Runtime created it, so later commands against the same container ID pick
+// the matching backend back up.
+type Runtime interface {
+	// Name returns the runtime's configured name, as used in
+	// EngineConfig.RuntimeName and the --oci-runtime flag.
+	Name() string
+	// Create creates the container described by engineConfig without
+	// starting its process.
+	Create(containerID string, engineConfig *EngineConfig) error
+	// Start starts a previously created container's process running.
+	Start(containerID string, engineConfig *EngineConfig) error
+	// Kill sends sig to the container's init process.
+	Kill(containerID string, engineConfig *EngineConfig, sig int) error
+	// Delete removes all runtime-owned state for the container.
+	Delete(containerID string, engineConfig *EngineConfig) error
+	// Exec runs cmdArgs inside the running container.
+	Exec(containerID string, engineConfig *EngineConfig, cmdArgs []string) error
+	// State returns the runtime's current view of the container.
+	State(containerID string, engineConfig *EngineConfig) (*specs.State, error)
+	// Checkpoint dumps the container's process tree to imagePath via CRIU
+	// (or the backend's native equivalent), per opts.
+	Checkpoint(containerID string, engineConfig *EngineConfig, imagePath string, opts CheckpointOptions) error
+	// Restore re-creates containerID's processes from a checkpoint
+	// previously written to imagePath.
+	Restore(containerID string, engineConfig *EngineConfig, imagePath string) error
+	// Pause freezes every process in the container in place.
+	Pause(containerID string, engineConfig *EngineConfig) error
+	// Resume unfreezes a container previously frozen by Pause.
+	Resume(containerID string, engineConfig *EngineConfig) error
+}
+
+// runtimes holds every Runtime constructor registered via RegisterRuntime,
+// keyed by name. Backends register themselves from an init() function.
+var runtimes = map[string]func(RuntimeBinary) Runtime{}
+
+// RegisterRuntime makes a Runtime backend available under name for
+// NewRuntime to construct.
+func RegisterRuntime(name string, ctor func(RuntimeBinary) Runtime) {
+	runtimes[name] = ctor
+}
+
+// NewRuntime looks up the backend registered as name and constructs it with
+// the binary/args configured for it in singularity.conf's [oci runtimes]
+// table. An empty name resolves to the built-in starter-based runtime. A
+// name with no registered backend but an entry in that table is driven by
+// the external-runtime adapter, using the configured binary.
+func NewRuntime(name string, binaries map[string]RuntimeBinary) (Runtime, error) {
+	if name == "" {
+		name = StarterRuntimeName
+	}
+
+	ctor, ok := runtimes[name]
+	if !ok {
+		bin, configured := binaries[name]
+		if !configured {
+			return nil, fmt.Errorf("no oci runtime backend registered or configured as %q", name)
+		}
+		return newExternalRuntime(name)(bin), nil
+	}
+
+	return ctor(binaries[name]), nil
+}
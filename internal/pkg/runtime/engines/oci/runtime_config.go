@@ -0,0 +1,64 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// LoadRuntimesConfig parses the "[oci runtimes]" table out of
+// singularity.conf. Each entry maps a runtime name to the binary that
+// implements it, and optional default arguments prepended to every
+// invocation:
+//
+//	[oci runtimes]
+//	runc = /usr/bin/runc
+//	crun = /usr/local/bin/crun --debug
+//
+// Names other than "starter" are looked up against this table when
+// NewRuntime is asked to construct them.
+func LoadRuntimesConfig(r io.Reader) (map[string]RuntimeBinary, error) {
+	binaries := make(map[string]RuntimeBinary)
+
+	inSection := false
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			inSection = strings.EqualFold(line, "[oci runtimes]")
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		name := strings.TrimSpace(parts[0])
+		fields := strings.Fields(strings.TrimSpace(parts[1]))
+		if len(fields) == 0 {
+			continue
+		}
+
+		binaries[name] = RuntimeBinary{
+			Path: fields[0],
+			Args: fields[1:],
+		}
+	}
+
+	return binaries, scanner.Err()
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+)
+
+func init() {
+	RegisterRuntime("runc", newExternalRuntime("runc"))
+	RegisterRuntime("crun", newExternalRuntime("crun"))
+}
+
+// externalRuntime drives a standard OCI-compliant runtime binary (runc,
+// crun, ...) through its conventional CLI, the way Podman generalized its
+// single bundled runtime into an OCIRuntime interface with multiple
+// backends.
+type externalRuntime struct {
+	name string
+	bin  RuntimeBinary
+}
+
+func newExternalRuntime(name string) func(RuntimeBinary) Runtime {
+	return func(bin RuntimeBinary) Runtime {
+		return &externalRuntime{name: name, bin: bin}
+	}
+}
+
+func (r *externalRuntime) Name() string {
+	return r.name
+}
+
+func (r *externalRuntime) binaryPath() string {
+	if r.bin.Path != "" {
+		return r.bin.Path
+	}
+	return r.name
+}
+
+func (r *externalRuntime) run(args ...string) ([]byte, error) {
+	allArgs := append(append([]string{}, r.bin.Args...), args...)
+
+	cmd := osexec.Command(r.binaryPath(), allArgs...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %v: %s: %s", r.binaryPath(), args, err, stderr.String())
+	}
+	return out, nil
+}
+
+func (r *externalRuntime) pidFilePath(containerID string) (string, error) {
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "pid"), nil
+}
+
+func (r *externalRuntime) Create(containerID string, engineConfig *EngineConfig) error {
+	pidFile, err := r.pidFilePath(containerID)
+	if err != nil {
+		return err
+	}
+
+	// Unlike the starter backend, nothing else persists engineConfig (with
+	// RuntimeName set) to the instance directory for us: do it ourselves,
+	// or getEngineConfig - and every later kill/delete/state/exec - has
+	// nothing to read back and can't even recover which backend to use.
+	if err := r.writeInstanceConfig(containerID, engineConfig); err != nil {
+		return err
+	}
+
+	_, err = r.run("create", "--bundle", engineConfig.GetBundlePath(), "--pid-file", pidFile, containerID)
+	return err
+}
+
+// writeInstanceConfig persists engineConfig to containerID's instance file,
+// wrapped the same way getEngineConfig expects to unmarshal it back out.
+func (r *externalRuntime) writeInstanceConfig(containerID string, engineConfig *EngineConfig) error {
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	commonConfig := &config.Common{
+		ContainerID:  containerID,
+		EngineName:   "oci",
+		EngineConfig: engineConfig,
+	}
+	configData, err := json.Marshal(commonConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal engine config: %s", err)
+	}
+
+	file, err := instance.Add(containerID)
+	if err != nil {
+		return err
+	}
+	file.Config = configData
+	return file.Update()
+}
+
+func (r *externalRuntime) Start(containerID string, engineConfig *EngineConfig) error {
+	_, err := r.run("start", containerID)
+	return err
+}
+
+func (r *externalRuntime) Kill(containerID string, engineConfig *EngineConfig, sig int) error {
+	_, err := r.run("kill", containerID, fmt.Sprintf("%d", sig))
+	return err
+}
+
+func (r *externalRuntime) Delete(containerID string, engineConfig *EngineConfig) error {
+	_, err := r.run("delete", containerID)
+
+	if file, ferr := instance.Get(containerID); ferr == nil {
+		file.Delete()
+	}
+
+	return err
+}
+
+func (r *externalRuntime) Exec(containerID string, engineConfig *EngineConfig, cmdArgs []string) error {
+	args := append([]string{"exec", containerID}, cmdArgs...)
+
+	cmd := osexec.Command(r.binaryPath(), append(append([]string{}, r.bin.Args...), args...)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (r *externalRuntime) State(containerID string, engineConfig *EngineConfig) (*specs.State, error) {
+	out, err := r.run("state", containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var state specs.State
+	if err := json.Unmarshal(out, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse %s state output: %s", r.name, err)
+	}
+	return &state, nil
+}
+
+func (r *externalRuntime) Checkpoint(containerID string, engineConfig *EngineConfig, imagePath string, opts CheckpointOptions) error {
+	if err := os.MkdirAll(imagePath, 0755); err != nil {
+		return err
+	}
+
+	args := []string{"checkpoint", "--image-path", imagePath}
+	if opts.LeaveRunning {
+		args = append(args, "--leave-running")
+	}
+	if opts.TCPEstablished {
+		args = append(args, "--tcp-established")
+	}
+	args = append(args, containerID)
+
+	if _, err := r.run(args...); err != nil {
+		return err
+	}
+
+	if err := writeCheckpointMetadata(imagePath, containerID, engineConfig); err != nil {
+		return err
+	}
+
+	if opts.Export != "" {
+		if err := exportCheckpoint(imagePath, opts.Export); err != nil {
+			return err
+		}
+		if !opts.Keep {
+			os.RemoveAll(imagePath)
+		}
+	}
+
+	return nil
+}
+
+func (r *externalRuntime) Restore(containerID string, engineConfig *EngineConfig, imagePath string) error {
+	pidFile, err := r.pidFilePath(containerID)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.run("restore", "--image-path", imagePath, "--bundle", engineConfig.GetBundlePath(), "--pid-file", pidFile, "--detach", containerID)
+	return err
+}
+
+func (r *externalRuntime) Pause(containerID string, engineConfig *EngineConfig) error {
+	if _, err := r.run("pause", containerID); err != nil {
+		return err
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+	return WritePausedState(dir, true)
+}
+
+func (r *externalRuntime) Resume(containerID string, engineConfig *EngineConfig) error {
+	if _, err := r.run("resume", containerID); err != nil {
+		return err
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+	return WritePausedState(dir, false)
+}
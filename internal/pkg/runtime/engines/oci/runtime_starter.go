@@ -0,0 +1,418 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package oci
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	osexec "os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/kr/pty"
+	specs "github.com/opencontainers/runtime-spec/specs-go"
+	"github.com/sylabs/singularity/internal/pkg/buildcfg"
+	"github.com/sylabs/singularity/internal/pkg/instance"
+	"github.com/sylabs/singularity/internal/pkg/runtime/engines/config"
+	"github.com/sylabs/singularity/internal/pkg/sylog"
+	"github.com/sylabs/singularity/internal/pkg/util/cgroups"
+	"github.com/sylabs/singularity/internal/pkg/util/exec"
+)
+
+func init() {
+	RegisterRuntime(StarterRuntimeName, newStarterRuntime)
+}
+
+// starterRuntime is the original, built-in backend: it launches containers
+// through Singularity's own starter binary and hands the console over to
+// smonitor once the starter has daemonized.
+type starterRuntime struct{}
+
+func newStarterRuntime(RuntimeBinary) Runtime {
+	return &starterRuntime{}
+}
+
+func (r *starterRuntime) Name() string {
+	return StarterRuntimeName
+}
+
+// criuBin is the CRIU binary invoked directly by Checkpoint, resolved from
+// PATH the same way the external runtime backends fall back to looking up
+// their binary by name.
+const criuBin = "criu"
+
+func (r *starterRuntime) Create(containerID string, engineConfig *EngineConfig) error {
+	return r.launch(containerID, engineConfig)
+}
+
+// launch hands the starter and the container's console over to smonitor,
+// which launches the starter itself so the container it daemonizes
+// reparents to smonitor rather than to init (see cmd/smonitor). It backs
+// both Create and Restore: the only difference between the two is whether
+// engineConfig.Restore is set, which the starter itself uses to decide
+// whether to re-materialize the container's processes via CRIU instead of
+// running them fresh (and, correspondingly, to skip re-firing prestart
+// hooks that already fired before the checkpoint).
+func (r *starterRuntime) launch(containerID string, engineConfig *EngineConfig) error {
+	starter := buildcfg.LIBEXECDIR + "/singularity/bin/starter"
+
+	commonConfig := &config.Common{
+		ContainerID:  containerID,
+		EngineName:   "oci",
+		EngineConfig: engineConfig,
+	}
+
+	configData, err := json.Marshal(commonConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal engine config: %s", err)
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	// smonitor reads this back to run the starter itself; see launch.go in
+	// cmd/smonitor.
+	configPath := filepath.Join(dir, "starter-config.json")
+	if err := ioutil.WriteFile(configPath, configData, 0600); err != nil {
+		return fmt.Errorf("failed to write starter config: %s", err)
+	}
+
+	hasTerminal := engineConfig.OciConfig.Process != nil && engineConfig.OciConfig.Process.Terminal
+
+	master, slave, err := openConsole(hasTerminal)
+	if err != nil {
+		return fmt.Errorf("failed to allocate container console: %s", err)
+	}
+
+	env := []string{sylog.GetEnvVar(), "SRUNTIME=oci"}
+	procName := fmt.Sprintf("Singularity OCI %s", containerID)
+
+	var healthcheck *HealthcheckSpec
+	if spec, ok := HealthcheckSpecFromAnnotations(engineConfig.OciConfig.Spec.Annotations); ok {
+		healthcheck = &spec
+	}
+
+	return startMonitor(containerID, dir, starter, procName, env, configPath, hasTerminal, master, slave, engineConfig.GetLogPath(), engineConfig.LogSizeMax, healthcheck)
+}
+
+// openConsole allocates the file descriptors that will back the container's
+// stdio: a PTY pair when the bundle asks for a terminal, or a plain pipe
+// otherwise. Both ends are handed to smonitor before it runs the starter:
+// smonitor wires the slave end to the starter as the container's stdio and
+// keeps the master end for itself.
+func openConsole(hasTerminal bool) (master, slave *os.File, err error) {
+	if hasTerminal {
+		return pty.Open()
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, w, nil
+}
+
+// startMonitor hands the container's console and the starter itself over
+// to a freshly exec'd smonitor process. smonitor - not launch - is the one
+// that runs the starter, marking itself a child subreaper first, so the
+// container the starter daemonizes reparents to smonitor instead of to
+// init; that's what lets smonitor reap it later and record its exit status
+// without the CLI needing to stay alive to observe it. Once smonitor has
+// the starter running it also takes over serving the attach/control
+// sockets, writing the CRI-format log and scheduling healthchecks. logPath
+// overrides where the rotated log sink is rooted; left empty, it defaults
+// to "container.log" inside the instance directory. logSizeMax of zero
+// uses monitor.DefaultLogSizeMax. healthcheck is nil when the bundle
+// carries no healthcheck annotation.
+//
+// startMonitor blocks until the starter has daemonized the container and
+// smonitor is serving its attach/control sockets, or until either step has
+// failed, so Create/Restore only return once that's known.
+func startMonitor(containerID, instanceDir, starterBin, starterProcName string, starterEnv []string, starterConfigPath string, hasTerminal bool, master, slave *os.File, logPath string, logSizeMax int64, healthcheck *HealthcheckSpec) error {
+	smonitorBin := buildcfg.LIBEXECDIR + "/singularity/bin/smonitor"
+
+	readyR, readyW, err := os.Pipe()
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return err
+	}
+
+	args := []string{
+		"-container-id", containerID,
+		"-instance-dir", instanceDir,
+		"-starter-bin", starterBin,
+		"-starter-proc-name", starterProcName,
+		"-starter-config-path", starterConfigPath,
+	}
+	if hasTerminal {
+		args = append(args, "-starter-tty")
+	}
+	for _, e := range starterEnv {
+		args = append(args, "-starter-env", e)
+	}
+	if logPath != "" {
+		args = append(args, "-log-path", logPath)
+	}
+	if logSizeMax > 0 {
+		args = append(args, "-log-size-max", fmt.Sprintf("%d", logSizeMax))
+	}
+	if healthcheck != nil {
+		args = append(args,
+			"-healthcheck-cmd", healthcheck.Cmd,
+			"-healthcheck-interval", healthcheck.Interval.String(),
+			"-healthcheck-timeout", healthcheck.Timeout.String(),
+			"-healthcheck-retries", fmt.Sprintf("%d", healthcheck.Retries),
+		)
+		if healthcheck.StartPeriod > 0 {
+			args = append(args, "-healthcheck-start-period", healthcheck.StartPeriod.String())
+		}
+	}
+
+	cmd := osexec.Command(smonitorBin, args...)
+	// fd 3/4/5: the console master, the starter's stdio slave, and the
+	// write end of the readiness pipe below, in that order - see the
+	// matching fd layout documented in cmd/smonitor/main.go.
+	cmd.ExtraFiles = []*os.File{master, slave, readyW}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		readyR.Close()
+		readyW.Close()
+		slave.Close()
+		master.Close()
+		return fmt.Errorf("failed to start monitor: %s", err)
+	}
+
+	master.Close()
+	slave.Close()
+	readyW.Close()
+
+	status, err := bufio.NewReader(readyR).ReadString('\n')
+	readyR.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read monitor readiness: %s", err)
+	}
+	if status = strings.TrimSpace(status); strings.HasPrefix(status, "ERR: ") {
+		return fmt.Errorf("failed to start container: %s", strings.TrimPrefix(status, "ERR: "))
+	}
+
+	return nil
+}
+
+func (r *starterRuntime) Start(containerID string, engineConfig *EngineConfig) error {
+	if engineConfig.State.Status != "created" {
+		return fmt.Errorf("container %s is not created", containerID)
+	}
+
+	// send SIGCONT signal to the instance
+	return syscall.Kill(engineConfig.State.Pid, syscall.SIGCONT)
+}
+
+func (r *starterRuntime) Kill(containerID string, engineConfig *EngineConfig, sig int) error {
+	if engineConfig.State.Status != "created" && engineConfig.State.Status != "running" {
+		return fmt.Errorf("container %s is nor created nor running", containerID)
+	}
+	return syscall.Kill(engineConfig.State.Pid, syscall.Signal(sig))
+}
+
+func (r *starterRuntime) Delete(containerID string, engineConfig *EngineConfig) error {
+	if engineConfig.State.Status != "stopped" {
+		return fmt.Errorf("container is not stopped")
+	}
+
+	hooks := engineConfig.OciConfig.Hooks
+	if hooks != nil {
+		for _, h := range hooks.Poststop {
+			if err := exec.Hook(&h, &engineConfig.State); err != nil {
+				sylog.Warningf("%s", err)
+			}
+		}
+	}
+
+	file, err := instance.Get(containerID)
+	if err != nil {
+		return err
+	}
+	return file.Delete()
+}
+
+func (r *starterRuntime) Exec(containerID string, engineConfig *EngineConfig, cmdArgs []string) error {
+	starter := buildcfg.LIBEXECDIR + "/singularity/bin/starter"
+
+	engineConfig.Exec = true
+	engineConfig.OciConfig.SetProcessArgs(cmdArgs)
+
+	commonConfig := &config.Common{
+		ContainerID:  containerID,
+		EngineName:   "oci",
+		EngineConfig: engineConfig,
+	}
+
+	configData, err := json.Marshal(commonConfig)
+	if err != nil {
+		return fmt.Errorf("failed to marshal engine config: %s", err)
+	}
+
+	env := []string{sylog.GetEnvVar(), "SRUNTIME=oci"}
+	procName := fmt.Sprintf("Singularity OCI %s", containerID)
+
+	return exec.Pipe(starter, []string{procName}, env, configData)
+}
+
+func (r *starterRuntime) State(containerID string, engineConfig *EngineConfig) (*specs.State, error) {
+	return &engineConfig.State, nil
+}
+
+func (r *starterRuntime) Checkpoint(containerID string, engineConfig *EngineConfig, imagePath string, opts CheckpointOptions) error {
+	if engineConfig.State.Status != "running" {
+		return fmt.Errorf("container %s is not running", containerID)
+	}
+
+	if err := os.MkdirAll(imagePath, 0755); err != nil {
+		return err
+	}
+
+	args := []string{
+		"dump",
+		"-t", fmt.Sprintf("%d", engineConfig.State.Pid),
+		"-D", imagePath,
+		"--shell-job",
+	}
+	if opts.LeaveRunning {
+		args = append(args, "--leave-running")
+	}
+	if opts.TCPEstablished {
+		args = append(args, "--tcp-established")
+	}
+
+	cmd := osexec.Command(criuBin, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("criu dump failed: %s", err)
+	}
+
+	if err := writeCheckpointMetadata(imagePath, containerID, engineConfig); err != nil {
+		return err
+	}
+
+	if opts.Export != "" {
+		if err := exportCheckpoint(imagePath, opts.Export); err != nil {
+			return err
+		}
+		if !opts.Keep {
+			os.RemoveAll(imagePath)
+		}
+	}
+
+	return nil
+}
+
+// Restore re-launches the starter with engineConfig.Restore set, so it
+// re-materializes the container's processes from the CRIU dump at
+// imagePath inside the same namespaces/cgroups Create would have made,
+// instead of running the bundle's process fresh. The starter itself reads
+// engineConfig.Restore to decide not to re-fire prestart hooks that already
+// ran before the checkpoint was taken - that decision lives inside the
+// starter's own engine process, outside this package.
+//
+// Unlike Create, where poststart fires when a later "oci start" resumes the
+// container with SIGCONT, CRIU resumes the restored processes immediately:
+// there is no separate start step for this backend to fire poststart from,
+// so Restore fires it itself once the starter has finished re-materializing
+// the container.
+func (r *starterRuntime) Restore(containerID string, engineConfig *EngineConfig, imagePath string) error {
+	engineConfig.Restore = true
+	engineConfig.RestoreImagePath = imagePath
+
+	if err := r.launch(containerID, engineConfig); err != nil {
+		return err
+	}
+
+	// engineConfig was built fresh from the dumped config.json by
+	// ociRestore, so its State is still the zero value (Pid 0, empty
+	// Status): reload it from the instance file the starter just wrote, so
+	// hooks that inspect the container see the real thing instead.
+	if state, err := reloadState(containerID); err != nil {
+		sylog.Warningf("failed to reload restored container state: %s", err)
+	} else {
+		engineConfig.State = *state
+	}
+
+	hooks := engineConfig.OciConfig.Hooks
+	if hooks != nil {
+		for _, h := range hooks.Poststart {
+			if err := exec.Hook(&h, &engineConfig.State); err != nil {
+				sylog.Warningf("%s", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// reloadState reads back the State the starter recorded for containerID in
+// its instance file, for callers (like Restore) that built their
+// engineConfig from elsewhere and never had it populated.
+func reloadState(containerID string) (*specs.State, error) {
+	file, err := instance.Get(containerID)
+	if err != nil {
+		return nil, err
+	}
+
+	commonConfig := config.Common{EngineConfig: &EngineConfig{}}
+	if err := json.Unmarshal(file.Config, &commonConfig); err != nil {
+		return nil, err
+	}
+
+	return &commonConfig.EngineConfig.(*EngineConfig).State, nil
+}
+
+func (r *starterRuntime) Pause(containerID string, engineConfig *EngineConfig) error {
+	if engineConfig.State.Status != "running" {
+		return fmt.Errorf("container %s is not running", containerID)
+	}
+
+	cg, err := cgroups.NewFromPid(engineConfig.State.Pid)
+	if err != nil {
+		return fmt.Errorf("failed to find cgroup for container %s: %s", containerID, err)
+	}
+	if err := cg.Freeze(); err != nil {
+		return fmt.Errorf("failed to freeze container %s: %s", containerID, err)
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+	return WritePausedState(dir, true)
+}
+
+func (r *starterRuntime) Resume(containerID string, engineConfig *EngineConfig) error {
+	cg, err := cgroups.NewFromPid(engineConfig.State.Pid)
+	if err != nil {
+		return fmt.Errorf("failed to find cgroup for container %s: %s", containerID, err)
+	}
+	if err := cg.Thaw(); err != nil {
+		return fmt.Errorf("failed to thaw container %s: %s", containerID, err)
+	}
+
+	dir, err := instance.GetDirPrivileged(containerID)
+	if err != nil {
+		return err
+	}
+	return WritePausedState(dir, false)
+}
@@ -0,0 +1,237 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package cgroups discovers and reads the cgroup hierarchy backing a
+// process, supporting both the legacy per-controller v1 layout and the
+// unified v2 layout, so callers such as "oci pause"/"oci stats" (and, down
+// the line, a healthcheck resource-pressure check) don't each have to
+// special-case both.
+package cgroups
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Version identifies which cgroup hierarchy layout a Cgroup was discovered
+// against.
+type Version int
+
+const (
+	// V1 is the legacy layout, with one hierarchy mounted per controller
+	// under /sys/fs/cgroup/<controller>.
+	V1 Version = iota + 1
+	// V2 is the unified layout, with every controller available under a
+	// single hierarchy mounted at /sys/fs/cgroup.
+	V2
+)
+
+const mountRoot = "/sys/fs/cgroup"
+
+// Cgroup is a handle to the cgroup a single process belongs to, discovered
+// from /proc/<pid>/cgroup.
+type Cgroup struct {
+	Pid     int
+	Version Version
+
+	// path is the absolute directory backing this cgroup under the v2
+	// unified hierarchy. Only set when Version is V2.
+	path string
+	// controllers maps each v1 controller name (eg "cpu", "memory",
+	// "freezer") to its absolute cgroup directory. Only set when Version
+	// is V1.
+	controllers map[string]string
+}
+
+// NewFromPid discovers the cgroup backing pid, detecting whether the host
+// uses the v1 or v2 layout.
+func NewFromPid(pid int) (*Cgroup, error) {
+	if isUnified() {
+		path, err := unifiedPath(pid)
+		if err != nil {
+			return nil, err
+		}
+		return &Cgroup{Pid: pid, Version: V2, path: filepath.Join(mountRoot, path)}, nil
+	}
+
+	controllers, err := v1ControllerPaths(pid)
+	if err != nil {
+		return nil, err
+	}
+	return &Cgroup{Pid: pid, Version: V1, controllers: controllers}, nil
+}
+
+// isUnified reports whether the host's cgroup v2 hierarchy is mounted.
+func isUnified() bool {
+	_, err := os.Stat(filepath.Join(mountRoot, "cgroup.controllers"))
+	return err == nil
+}
+
+// procCgroupLines parses /proc/<pid>/cgroup, returning one (hierarchy ID,
+// controller list, path) tuple per line.
+func procCgroupLines(pid int) ([][3]string, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines [][3]string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		lines = append(lines, [3]string{fields[0], fields[1], fields[2]})
+	}
+	return lines, scanner.Err()
+}
+
+// unifiedPath returns the cgroup path for pid's single v2 hierarchy entry
+// (the line with an empty controller list, conventionally "0::<path>").
+func unifiedPath(pid int) (string, error) {
+	lines, err := procCgroupLines(pid)
+	if err != nil {
+		return "", err
+	}
+
+	for _, l := range lines {
+		if l[1] == "" {
+			return l[2], nil
+		}
+	}
+	return "", fmt.Errorf("no unified cgroup entry found for pid %d", pid)
+}
+
+// v1ControllerPaths returns, for each v1 controller pid belongs to, the
+// absolute directory of its cgroup under that controller's mountpoint.
+func v1ControllerPaths(pid int) (map[string]string, error) {
+	lines, err := procCgroupLines(pid)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make(map[string]string)
+	for _, l := range lines {
+		if l[1] == "" {
+			continue
+		}
+		for _, controller := range strings.Split(l[1], ",") {
+			paths[controller] = filepath.Join(mountRoot, controller, l[2])
+		}
+	}
+	return paths, nil
+}
+
+// controllerPath returns the directory backing controller for a v1 cgroup,
+// or the unified hierarchy's directory for a v2 one (v2 controller files
+// all live in the same directory, so the controller name is irrelevant).
+func (c *Cgroup) controllerPath(controller string) (string, error) {
+	if c.Version == V2 {
+		return c.path, nil
+	}
+
+	path, ok := c.controllers[controller]
+	if !ok {
+		return "", fmt.Errorf("%s controller not available for this cgroup", controller)
+	}
+	return path, nil
+}
+
+// Freeze suspends every process in the cgroup.
+func (c *Cgroup) Freeze() error {
+	if c.Version == V2 {
+		return ioutil.WriteFile(filepath.Join(c.path, "cgroup.freeze"), []byte("1"), 0644)
+	}
+
+	path, err := c.controllerPath("freezer")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(path, "freezer.state"), []byte("FROZEN"), 0644)
+}
+
+// Thaw resumes every process in the cgroup previously suspended by Freeze.
+func (c *Cgroup) Thaw() error {
+	if c.Version == V2 {
+		return ioutil.WriteFile(filepath.Join(c.path, "cgroup.freeze"), []byte("0"), 0644)
+	}
+
+	path, err := c.controllerPath("freezer")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(path, "freezer.state"), []byte("THAWED"), 0644)
+}
+
+func readUint64File(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	v := strings.TrimSpace(string(data))
+	if v == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(v, 10, 64)
+}
+
+// readInt64File is like readUint64File but signed, for v1 cgroup files such
+// as cpu.cfs_quota_us that use -1 to mean "no quota".
+func readInt64File(path string) (int64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// parseStatFile parses the "key value\n" per-line format used by cgroupfs
+// stat files such as cpu.stat and memory.stat (v1 and v2 alike).
+func parseStatFile(data []byte) map[string]uint64 {
+	out := make(map[string]uint64)
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			out[fields[0]] = n
+		}
+	}
+
+	return out
+}
+
+// sumIOStatField sums field (eg "rbytes", "wbytes") across every per-device
+// line of a v2 io.stat file, whose lines look like
+// "<maj>:<min> rbytes=N wbytes=N rios=N wios=N ...".
+func sumIOStatField(data []byte, field string) uint64 {
+	var total uint64
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		for _, f := range strings.Fields(scanner.Text()) {
+			kv := strings.SplitN(f, "=", 2)
+			if len(kv) != 2 || kv[0] != field {
+				continue
+			}
+			if n, err := strconv.ParseUint(kv[1], 10, 64); err == nil {
+				total += n
+			}
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,154 @@
+// Copyright (c) 2019, Sylabs Inc. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package cgroups
+
+import (
+	"bufio"
+	"io/ioutil"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of a cgroup's resource usage, read from
+// whichever of the v1/v2 controller files are available.
+type Stats struct {
+	// CPUUsage is the cumulative CPU time consumed by every process that
+	// has ever been in the cgroup.
+	CPUUsage time.Duration
+	// MemoryUsage is the current resident memory usage, in bytes.
+	MemoryUsage uint64
+	// MemoryLimit is the memory usage the cgroup is capped at, in bytes,
+	// or 0 when unlimited.
+	MemoryLimit uint64
+	// PIDs is the number of tasks currently in the cgroup.
+	PIDs uint64
+	// IOReadBytes and IOWriteBytes are the cumulative bytes read from and
+	// written to block devices by the cgroup.
+	IOReadBytes  uint64
+	IOWriteBytes uint64
+	// CPULimit is the number of CPUs the cgroup's CPU quota caps it at, as
+	// a fraction (eg 1.5 for "1.5 CPUs"). It falls back to the host's CPU
+	// count when the cgroup has no quota set.
+	CPULimit float64
+}
+
+// Stats reads a fresh snapshot of the cgroup's resource usage.
+func (c *Cgroup) Stats() (*Stats, error) {
+	if c.Version == V2 {
+		return c.statsV2()
+	}
+	return c.statsV1()
+}
+
+func (c *Cgroup) statsV2() (*Stats, error) {
+	s := &Stats{}
+
+	if data, err := ioutil.ReadFile(filepath.Join(c.path, "cpu.stat")); err == nil {
+		s.CPUUsage = time.Duration(parseStatFile(data)["usage_usec"]) * time.Microsecond
+	}
+
+	if n, err := readUint64File(filepath.Join(c.path, "memory.current")); err == nil {
+		s.MemoryUsage = n
+	}
+	if n, err := readUint64File(filepath.Join(c.path, "memory.max")); err == nil {
+		s.MemoryLimit = n
+	}
+	if n, err := readUint64File(filepath.Join(c.path, "pids.current")); err == nil {
+		s.PIDs = n
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(c.path, "io.stat")); err == nil {
+		s.IOReadBytes = sumIOStatField(data, "rbytes")
+		s.IOWriteBytes = sumIOStatField(data, "wbytes")
+	}
+
+	s.CPULimit = float64(goruntime.NumCPU())
+	if data, err := ioutil.ReadFile(filepath.Join(c.path, "cpu.max")); err == nil {
+		fields := strings.Fields(strings.TrimSpace(string(data)))
+		if len(fields) == 2 && fields[0] != "max" {
+			if quota, err := strconv.ParseFloat(fields[0], 64); err == nil {
+				if period, err := strconv.ParseFloat(fields[1], 64); err == nil && period > 0 {
+					s.CPULimit = quota / period
+				}
+			}
+		}
+	}
+
+	return s, nil
+}
+
+func (c *Cgroup) statsV1() (*Stats, error) {
+	s := &Stats{CPULimit: float64(goruntime.NumCPU())}
+
+	if path, err := c.controllerPath("cpuacct"); err == nil {
+		if n, err := readUint64File(filepath.Join(path, "cpuacct.usage")); err == nil {
+			s.CPUUsage = time.Duration(n) * time.Nanosecond
+		}
+	}
+
+	if path, err := c.controllerPath("cpu"); err == nil {
+		quota, quotaErr := readInt64File(filepath.Join(path, "cpu.cfs_quota_us"))
+		period, periodErr := readUint64File(filepath.Join(path, "cpu.cfs_period_us"))
+		if quotaErr == nil && periodErr == nil && quota > 0 && period > 0 {
+			s.CPULimit = float64(quota) / float64(period)
+		}
+	}
+
+	if path, err := c.controllerPath("memory"); err == nil {
+		if n, err := readUint64File(filepath.Join(path, "memory.usage_in_bytes")); err == nil {
+			s.MemoryUsage = n
+		}
+		if n, err := readUint64File(filepath.Join(path, "memory.limit_in_bytes")); err == nil {
+			// an unset v1 limit reads back as a very large sentinel
+			// value rather than "max"; normalize it to 0 the same way
+			// the v2 reader reports "unlimited".
+			if n < 1<<62 {
+				s.MemoryLimit = n
+			}
+		}
+	}
+
+	if path, err := c.controllerPath("pids"); err == nil {
+		if n, err := readUint64File(filepath.Join(path, "pids.current")); err == nil {
+			s.PIDs = n
+		}
+	}
+
+	if path, err := c.controllerPath("blkio"); err == nil {
+		if data, err := ioutil.ReadFile(filepath.Join(path, "blkio.throttle.io_service_bytes")); err == nil {
+			s.IOReadBytes, s.IOWriteBytes = parseBlkioServiceBytes(data)
+		}
+	}
+
+	return s, nil
+}
+
+// parseBlkioServiceBytes parses blkio.throttle.io_service_bytes, whose
+// lines look like "<maj>:<min> Read N" / "<maj>:<min> Write N" / "... Total
+// N", summing the per-device Read/Write totals across every device.
+func parseBlkioServiceBytes(data []byte) (read, write uint64) {
+	return parseBlkioField(data, "Read"), parseBlkioField(data, "Write")
+}
+
+func parseBlkioField(data []byte, op string) uint64 {
+	var total uint64
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 || fields[1] != op {
+			continue
+		}
+		if n, err := strconv.ParseUint(fields[2], 10, 64); err == nil {
+			total += n
+		}
+	}
+
+	return total
+}